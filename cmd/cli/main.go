@@ -2,9 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
+	"os/exec"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -12,11 +20,21 @@ import (
 	"dify-wp-sync/internal/config"
 	"dify-wp-sync/internal/dify"
 	"dify-wp-sync/internal/logger"
-	"dify-wp-sync/internal/redisstore"
+	"dify-wp-sync/internal/oauth"
+	"dify-wp-sync/internal/scheduler"
+	"dify-wp-sync/internal/secrets"
+	"dify-wp-sync/internal/sink"
 	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/storage"
+	"dify-wp-sync/internal/storage/backend"
+	"dify-wp-sync/internal/syncer"
 	"dify-wp-sync/internal/wpcom"
+	"dify-wp-sync/internal/wxr"
 )
 
+// triggerCLI marks sync runs started from the command line.
+const triggerCLI = "cli"
+
 func main() {
 	if len(os.Args) < 2 {
 		printUsageAndExit()
@@ -29,34 +47,81 @@ func main() {
 		logger.Log.Fatalf("Error loading config: %v", err)
 	}
 
-	store := redisstore.New(cfg.RedisAddr, cfg.RedisPwd, cfg.RedisDB)
-	sitesMgr := sites.NewManager(store)
+	keys, err := secrets.LoadKeys(cfg.EncryptionKeys, cfg.EncryptionKeysFile)
+	if err != nil {
+		logger.Log.Fatalf("Error loading encryption keys: %v", err)
+	}
+	if keys == nil {
+		logger.Log.Warnf("ENCRYPTION_KEYS is not set; access tokens are stored in plaintext")
+	}
+
+	if cmd == "migrate-store" {
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: cli migrate-store <from> <to>   (each one of redis, bolt, bolt:<path>)")
+			os.Exit(1)
+		}
+		migrateStore(context.Background(), cfg, keys, os.Args[2], os.Args[3])
+		return
+	}
+
+	store, err := backend.Open(cfg)
+	if err != nil {
+		logger.Log.Fatalf("Error opening %s store: %v", cfg.Store, err)
+	}
+	defer store.Close()
+	sitesMgr := sites.NewManager(store, keys)
+	sitesMgr.WPComAPIBase = cfg.WPComAPIBase
 	difyClient := dify.NewDifyClient(cfg.DifyToken, cfg.DifyBaseURL)
 	ctx := context.Background()
 
+	var sinks []sink.Sink
+	if cfg.ExportDir != "" {
+		sinks = append(sinks, sink.NewFilesystem(cfg.ExportDir))
+	}
+
 	switch cmd {
 	case "list-sites":
 		listSites(ctx, sitesMgr)
 	case "sync-site":
 		if len(os.Args) < 3 {
-			fmt.Println("Usage: cli sync-site <site_id>")
+			fmt.Println("Usage: cli sync-site <site_id> [--wait] [--prune]")
 			os.Exit(1)
 		}
 		siteID := os.Args[2]
-		syncSite(ctx, sitesMgr, difyClient, siteID)
+		syncSite(ctx, sitesMgr, difyClient, siteID, false, hasFlag("--wait"), hasFlag("--prune"), sinks)
 	case "sync-all-sites":
-		syncAllSites(ctx, sitesMgr, difyClient)
+		syncAllSites(ctx, sitesMgr, difyClient, sinks)
 	case "open-oauth":
-		openOAuthPortal(cfg)
+		fs := flag.NewFlagSet("open-oauth", flag.ExitOnError)
+		datasetID := fs.String("dataset", "", "Existing Dify dataset ID to sync into instead of creating one")
+		postTypesStr := fs.String("post-types", "", "Comma-separated post types to sync")
+		fresh := fs.Bool("fresh", false, "Start an already registered site over with a new dataset and mapping")
+		global := fs.Bool("global", false, "Ask for a token covering all of the user's sites and pick the sites to connect")
+		siteIDs := fs.String("sites", "", "Comma-separated site IDs to connect with a global token instead of picking them")
+		fs.Parse(os.Args[2:])
+		openOAuthPortal(ctx, cfg, store, connectIntent(*datasetID, *postTypesStr, *fresh, *global, *siteIDs))
+	case "connect":
+		fs := flag.NewFlagSet("connect", flag.ExitOnError)
+		datasetID := fs.String("dataset", "", "Existing Dify dataset ID to sync into instead of creating one")
+		postTypesStr := fs.String("post-types", "", "Comma-separated post types to sync")
+		port := fs.Int("port", defaultConnectPort, "Local port for the OAuth callback; http://127.0.0.1:<port>/oauth/callback must be a registered redirect URI")
+		noBrowser := fs.Bool("no-browser", false, "Only print the authorization URL")
+		fresh := fs.Bool("fresh", false, "Start an already registered site over with a new dataset and mapping")
+		global := fs.Bool("global", false, "Ask for a token covering all of the user's sites and pick the sites to connect")
+		siteIDs := fs.String("sites", "", "Comma-separated site IDs to connect with a global token instead of picking them")
+		fs.Parse(os.Args[2:])
+		if *port <= 0 || *port > 65535 {
+			fmt.Println("--port must be the port of a registered redirect URI")
+			os.Exit(1)
+		}
+		connectSite(ctx, cfg, store, sitesMgr, difyClient, connectIntent(*datasetID, *postTypesStr, *fresh, *global, *siteIDs), *port, !*noBrowser)
 	case "force-sync-site":
 		if len(os.Args) < 3 {
-			fmt.Println("Usage: cli force-sync-site <site_id>")
+			fmt.Println("Usage: cli force-sync-site <site_id> [--wait]")
 			os.Exit(1)
 		}
 		siteID := os.Args[2]
-
-		forceSyncSite(ctx, sitesMgr, siteID)
-		syncSite(ctx, sitesMgr, difyClient, siteID)
+		syncSite(ctx, sitesMgr, difyClient, siteID, true, hasFlag("--wait"), false, sinks)
 	case "force-sync-doc":
 		if len(os.Args) < 4 {
 			fmt.Println("Usage: cli force-sync-doc <site_id> <post_id>")
@@ -87,6 +152,92 @@ func main() {
 		}
 		siteID := os.Args[2]
 		fixDataset(ctx, sitesMgr, difyClient, siteID)
+	case "rebuild-mapping":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: cli rebuild-mapping <site_id> [--wait]")
+			os.Exit(1)
+		}
+		rebuildMapping(ctx, sitesMgr, difyClient, os.Args[2], hasFlag("--wait"))
+	case "add-selfhosted-site":
+		if len(os.Args) < 5 {
+			fmt.Println("Usage: cli add-selfhosted-site <blog_url> <username> <app_password> [post_types_comma_separated]")
+			os.Exit(1)
+		}
+		postTypesStr := ""
+		if len(os.Args) > 5 {
+			postTypesStr = os.Args[5]
+		}
+		addSelfHostedSite(ctx, sitesMgr, difyClient, os.Args[2], os.Args[3], os.Args[4], postTypesStr)
+	case "add-feed-site":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: cli add-feed-site <sitemap_or_feed_url>")
+			os.Exit(1)
+		}
+		addFeedSite(ctx, sitesMgr, difyClient, os.Args[2])
+	case "export-site":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: cli export-site <site_id> <dir>")
+			os.Exit(1)
+		}
+		exportSite(ctx, sitesMgr, os.Args[2], os.Args[3])
+	case "set-webhook-secret":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: cli set-webhook-secret <site_id> [secret]")
+			os.Exit(1)
+		}
+		secret := ""
+		if len(os.Args) > 3 {
+			secret = os.Args[3]
+		}
+		setWebhookSecret(ctx, sitesMgr, os.Args[2], secret)
+	case "set-schedule":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: cli set-schedule <site_id> <cron_expression|interval|off>")
+			os.Exit(1)
+		}
+		setSchedule(ctx, sitesMgr, os.Args[2], os.Args[3])
+	case "pause-site", "resume-site":
+		if len(os.Args) < 3 {
+			fmt.Printf("Usage: cli %s <site_id>\n", cmd)
+			os.Exit(1)
+		}
+		setPaused(ctx, sitesMgr, os.Args[2], cmd == "pause-site")
+	case "sync-runs":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: cli sync-runs <site_id>")
+			os.Exit(1)
+		}
+		listSyncRuns(ctx, sitesMgr, os.Args[2])
+	case "import-wxr":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: cli import-wxr <file> [--dataset <dataset_id>] [--post-types <post_types_comma_separated>] [--wait]")
+			os.Exit(1)
+		}
+		fs := flag.NewFlagSet("import-wxr", flag.ExitOnError)
+		datasetID := fs.String("dataset", "", "Dify dataset ID to import into (required for new sites)")
+		postTypesStr := fs.String("post-types", "", "Comma-separated post types to import (defaults to the site's post types)")
+		wait := fs.Bool("wait", false, "Wait for a running sync of the same site to finish")
+		fs.Parse(os.Args[3:])
+		importWXR(ctx, sitesMgr, difyClient, os.Args[2], *datasetID, *postTypesStr, *wait, sinks)
+	case "migrate":
+		migrateSites(ctx, sitesMgr)
+	case "remove-site":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: cli remove-site <site_id> [--keep-dataset | --documents-only] [--yes]")
+			os.Exit(1)
+		}
+		cleanup := syncer.CleanupDataset
+		if hasFlag("--documents-only") {
+			cleanup = syncer.CleanupDocuments
+		}
+		if hasFlag("--keep-dataset") {
+			cleanup = syncer.CleanupKeep
+		}
+		removeSite(ctx, sitesMgr, difyClient, os.Args[2], cleanup, hasFlag("--yes"))
+	case "rotate-keys":
+		rotateKeys(ctx, sitesMgr)
+	case "check-tokens":
+		checkTokens(ctx, cfg, store, sitesMgr)
 	default:
 		fmt.Printf("Unknown command: %s\n", cmd)
 		os.Exit(1)
@@ -97,13 +248,29 @@ func printUsageAndExit() {
 	fmt.Println("Usage: cli <command> [args...]")
 	fmt.Println("Commands:")
 	fmt.Println("  list-sites")
-	fmt.Println("  sync-site <site_id>")
+	fmt.Println("  sync-site <site_id> [--wait] [--prune]")
 	fmt.Println("  sync-all-sites")
-	fmt.Println("  open-oauth")
-	fmt.Println("  force-sync-site <site_id>")
+	fmt.Println("  open-oauth [--dataset <dataset_id>] [--post-types <post_types_comma_separated>] [--fresh] [--global [--sites <site_ids_comma_separated>]]")
+	fmt.Println("  connect [--dataset <dataset_id>] [--post-types <post_types_comma_separated>] [--port <port>] [--no-browser] [--fresh] [--global [--sites <site_ids_comma_separated>]]")
+	fmt.Println("  force-sync-site <site_id> [--wait]")
 	fmt.Println("  force-sync-doc <site_id> <post_id>")
 	fmt.Println("  set-post-types <site_id> <post_types_comma_separated>")
 	fmt.Println("  fix-dataset <site_id>")
+	fmt.Println("  rebuild-mapping <site_id> [--wait]")
+	fmt.Println("  add-selfhosted-site <blog_url> <username> <app_password> [post_types_comma_separated]")
+	fmt.Println("  add-feed-site <sitemap_or_feed_url>")
+	fmt.Println("  import-wxr <file> [--dataset <dataset_id>] [--post-types <post_types_comma_separated>] [--wait]")
+	fmt.Println("  export-site <site_id> <dir>")
+	fmt.Println("  set-webhook-secret <site_id> [secret]")
+	fmt.Println("  set-schedule <site_id> <cron_expression|interval|off>")
+	fmt.Println("  pause-site <site_id>")
+	fmt.Println("  resume-site <site_id>")
+	fmt.Println("  sync-runs <site_id>")
+	fmt.Println("  migrate")
+	fmt.Println("  migrate-store <from> <to>")
+	fmt.Println("  remove-site <site_id> [--keep-dataset | --documents-only] [--yes]")
+	fmt.Println("  rotate-keys")
+	fmt.Println("  check-tokens")
 	os.Exit(1)
 }
 
@@ -119,30 +286,52 @@ func listSites(ctx context.Context, sm *sites.Manager) {
 	}
 	fmt.Println("Registered Sites:")
 	for _, s := range allSites {
-		fmt.Printf("- SiteID: %s, BlogURL: %s, LastSync: %s, PostTypes: %v\n",
-			s.SiteID, s.BlogURL, s.LastSyncTime, s.PostTypes)
+		status := s.Status
+		if status == "" {
+			status = sites.StatusActive
+		}
+		fmt.Printf("- SiteID: %s, BlogURL: %s, Source: %s, Status: %s, LastSync: %s, PostTypes: %v, Schedule: %q, Paused: %t\n",
+			s.SiteID, s.BlogURL, s.Source(), status, s.LastSyncTime, s.PostTypes, s.SyncSchedule, s.SyncPaused)
 	}
 }
 
-func syncSite(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient, siteID string) {
-	sc, err := sm.GetSite(ctx, siteID)
-	if err != nil {
-		logger.Log.Errorf("Failed to get site %s: %v", siteID, err)
+// cliLockWait is how long --wait waits for another sync of the same site to finish.
+const cliLockWait = 30 * time.Minute
+
+// hasFlag reports whether a boolean flag was passed anywhere after the command.
+func hasFlag(name string) bool {
+	for _, a := range os.Args[2:] {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// syncSite syncs one site; with reset set, every document is recreated (force-sync-site).
+// Without wait it fails immediately if another sync of the site is running. prune
+// looks for deleted posts even if that was done recently.
+func syncSite(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient, siteID string, reset, wait, prune bool, sinks []sink.Sink) {
+	opts := syncer.RunOptions{Trigger: triggerCLI, Reset: reset, Prune: prune, Sinks: sinks}
+	if wait {
+		opts.LockWait = cliLockWait
+	}
+	err := syncer.SyncRegisteredSite(ctx, sm, difyCli, siteID, opts)
+	if errors.Is(err, sites.ErrSyncInProgress) {
+		fmt.Printf("%v. Retry later or pass --wait.\n", err)
 		os.Exit(1)
 	}
-	err = wpcom.SyncSite(ctx, sc, difyCli)
 	if err != nil {
 		logger.Log.Errorf("Failed to sync site %s: %v", siteID, err)
 		os.Exit(1)
 	}
-	if err := sm.UpdateSite(ctx, sc); err != nil {
-		logger.Log.Errorf("Failed to update site %s after sync: %v", siteID, err)
-		os.Exit(1)
+	if reset {
+		fmt.Printf("Site %s has been reset and all documents recreated.\n", siteID)
 	}
 	fmt.Printf("Site %s synced successfully.\n", siteID)
 }
 
-func syncAllSites(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient) {
+func syncAllSites(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient, sinks []sink.Sink) {
 	allSites, err := sm.ListSites(ctx)
 	if err != nil {
 		logger.Log.Errorf("Failed to list sites: %v", err)
@@ -154,42 +343,112 @@ func syncAllSites(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClie
 	}
 
 	for _, sc := range allSites {
-		err := wpcom.SyncSite(ctx, sc, difyCli)
-		if err != nil {
-			logger.Log.Errorf("Failed to sync site %s: %v", sc.SiteID, err)
+		if sc.Status == sites.StatusNeedsReauth {
+			fmt.Printf("Site %s skipped: needs re-authorization (see check-tokens).\n", sc.SiteID)
+			continue
+		}
+		if sc.SyncPaused || !sc.Active() || sc.Source() == sites.SourceWXR {
+			fmt.Printf("Site %s skipped (paused, inactive or WXR-only).\n", sc.SiteID)
+			continue
+		}
+		err := syncer.SyncRegisteredSite(ctx, sm, difyCli, sc.SiteID, syncer.RunOptions{Trigger: triggerCLI, Sinks: sinks})
+		if errors.Is(err, sites.ErrSyncInProgress) {
+			fmt.Printf("Site %s skipped: %v.\n", sc.SiteID, err)
 			continue
 		}
-		if err := sm.UpdateSite(ctx, sc); err != nil {
-			logger.Log.Errorf("Failed to update site %s after sync: %v", sc.SiteID, err)
+		if err != nil {
+			logger.Log.Errorf("Failed to sync site %s: %v", sc.SiteID, err)
 			continue
 		}
 		fmt.Printf("Site %s synced successfully.\n", sc.SiteID)
 	}
 }
 
-func openOAuthPortal(cfg *config.Config) {
-	oauthURL := fmt.Sprintf(
-		"https://public-api.wordpress.com/oauth2/authorize?client_id=%s&redirect_uri=%s&response_type=code",
-		cfg.ClientID, url.QueryEscape(cfg.RedirectURI),
-	)
+// newOAuthManager returns an OAuthManager for the configured app and endpoints.
+func newOAuthManager(cfg *config.Config) *oauth.OAuthManager {
+	return oauth.NewOAuthManager(cfg.ClientID, cfg.ClientSecret, cfg.RedirectURI,
+		oauth.WithOAuthBase(cfg.WPComOAuthBase), oauth.WithAPIBase(cfg.WPComAPIBase))
+}
 
-	fmt.Println("Open the following URL in your browser to authorize your site:")
-	fmt.Println(oauthURL)
+// connectIntent builds the intent of a connect flow from the command's flags.
+func connectIntent(datasetID, postTypesStr string, fresh, global bool, siteIDs string) *oauth.Intent {
+	intent := &oauth.Intent{DatasetID: datasetID, Fresh: fresh, Global: global || siteIDs != ""}
+	if postTypesStr != "" {
+		intent.PostTypes = strings.Split(postTypesStr, ",")
+	}
+	if siteIDs != "" {
+		intent.SiteIDs = strings.Split(siteIDs, ",")
+	}
+	return intent
 }
 
-func forceSyncSite(ctx context.Context, sm *sites.Manager, siteID string) {
-	sc, err := sm.GetSite(ctx, siteID)
+// openOAuthPortal starts a connect flow handled by the server, storing the intent
+// under a state value just like /oauth/start does. The printed link goes through the
+// server's /oauth/begin, which binds the flow to the browser that opens it first.
+func openOAuthPortal(ctx context.Context, cfg *config.Config, store storage.Store, intent *oauth.Intent) {
+	state, err := oauth.NewStateStore(store, cfg.ClientSecret).Create(ctx, intent)
+	if err != nil {
+		logger.Log.Errorf("Failed to store OAuth state: %v", err)
+		os.Exit(1)
+	}
+	om := newOAuthManager(cfg)
+
+	fmt.Println("Open the following URL in your browser within 15 minutes to authorize your site:")
+	fmt.Println(om.BeginURL(state))
+}
+
+// defaultConnectPort is the port connect listens on unless --port is given. The
+// WordPress.com app must list http://127.0.0.1:8976/oauth/callback as a redirect URI.
+const defaultConnectPort = 8976
+
+// connectSite connects a WordPress.com site without the server: it catches the OAuth
+// callback on a temporary listener on 127.0.0.1 and registers the site directly.
+func connectSite(ctx context.Context, cfg *config.Config, store storage.Store, sm *sites.Manager, difyCli *dify.DifyClient, intent *oauth.Intent, port int, browser bool) {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
 	if err != nil {
-		logger.Log.Errorf("Failed to get site %s for force-sync: %v", siteID, err)
+		logger.Log.Errorf("Failed to start local callback listener: %v", err)
 		os.Exit(1)
 	}
-	sc.PostDocMapping = make(map[int]string)
-	sc.LastSyncTime = time.Time{}
-	if err := sm.UpdateSite(ctx, sc); err != nil {
-		logger.Log.Errorf("Failed to update site %s for force-sync: %v", siteID, err)
+	defer ln.Close()
+
+	ah := &oauth.AuthHandler{
+		Oauth:    newOAuthManager(cfg),
+		SitesMgr: sm,
+		DifyCli:  difyCli,
+		States:   oauth.NewStateStore(store, cfg.ClientSecret),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, oauth.StateTTL)
+	defer cancel()
+	outcome, err := oauth.ConnectLoopback(ctx, ah, ln, intent, func(beginURL string) {
+		fmt.Println("Open the following URL in your browser to authorize your site:")
+		fmt.Println(beginURL)
+		if browser {
+			if err := openBrowser(beginURL); err != nil {
+				logger.Log.Warnf("Could not open a browser: %v", err)
+			}
+		}
+		fmt.Println("Waiting for authorization...")
+	})
+	for i, site := range outcome["site"] {
+		fmt.Printf("Site connected: %s (dataset: %s)\n", site, outcome["dataset"][i])
+	}
+	if err != nil {
+		logger.Log.Errorf("Failed to connect site: %v", err)
 		os.Exit(1)
 	}
-	fmt.Printf("Site %s has been reset. The next sync will recreate all documents.\n", siteID)
+}
+
+// openBrowser opens target in the user's default browser.
+func openBrowser(target string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", target).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", target).Start()
+	default:
+		return exec.Command("xdg-open", target).Start()
+	}
 }
 
 func forceSyncDoc(ctx context.Context, sm *sites.Manager, siteID string, postID int) {
@@ -198,10 +457,9 @@ func forceSyncDoc(ctx context.Context, sm *sites.Manager, siteID string, postID
 		logger.Log.Errorf("Failed to get site %s for force-sync-doc: %v", siteID, err)
 		os.Exit(1)
 	}
-	delete(sc.PostDocMapping, postID)
-	if err := sm.UpdateSite(ctx, sc); err != nil {
-		logger.Log.Errorf("Failed to update site %s after removing doc mapping for post %d: %v",
-			siteID, postID, err)
+	if err := sm.RemoveDocID(ctx, sc, postID); err != nil {
+		logger.Log.Errorf("Failed to remove doc mapping for post %d on site %s: %v",
+			postID, siteID, err)
 		os.Exit(1)
 	}
 	fmt.Printf(
@@ -211,15 +469,13 @@ func forceSyncDoc(ctx context.Context, sm *sites.Manager, siteID string, postID
 }
 
 func setSitePostTypes(ctx context.Context, sm *sites.Manager, siteID, postTypesStr string) {
-	sc, err := sm.GetSite(ctx, siteID)
-	if err != nil {
-		logger.Log.Errorf("Failed to get site %s for setting post types: %v", siteID, err)
-		os.Exit(1)
-	}
 	postTypes := strings.Split(postTypesStr, ",")
-	sc.PostTypes = postTypes
-	if err := sm.UpdateSite(ctx, sc); err != nil {
-		logger.Log.Errorf("Failed to update site %s after setting post types: %v", siteID, err)
+	_, err := sm.ModifySite(ctx, siteID, triggerCLI+":set-post-types", cliLockWait, func(sc *sites.SiteConfig) error {
+		sc.PostTypes = postTypes
+		return nil
+	})
+	if err != nil {
+		logger.Log.Errorf("Failed to set post types of site %s: %v", siteID, err)
 		os.Exit(1)
 	}
 	fmt.Printf("Post types for site %s updated to: %v\n", siteID, postTypes)
@@ -234,7 +490,7 @@ func fixDataset(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient
 		os.Exit(1)
 	}
 
-	exists, err := difyCli.DatasetExists(sc.DifyDatasetID)
+	exists, err := difyCli.DatasetExists(ctx, sc.DifyDatasetID)
 	if err != nil {
 		logger.Log.Errorf("Failed to list all datasets for site %s: %v", siteID, err)
 		os.Exit(1)
@@ -253,17 +509,607 @@ func fixDataset(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient
 		return
 	}
 
-	newID, err := difyCli.CreateDataset(sc.BlogURL)
+	newID, err := difyCli.CreateDataset(ctx, sc.BlogURL)
 	if err != nil {
 		logger.Log.Errorf("Failed to create new dataset for site %s: %v", siteID, err)
 		os.Exit(1)
 	}
 
-	sc.DifyDatasetID = newID
-	if err := sm.UpdateSite(ctx, sc); err != nil {
+	oldID := sc.DifyDatasetID
+	_, err = sm.ModifySite(ctx, siteID, triggerCLI+":fix-dataset", cliLockWait, func(sc *sites.SiteConfig) error {
+		if sc.DifyDatasetID != oldID {
+			return fmt.Errorf("the site's dataset changed to %s meanwhile; dataset %s is not used", sc.DifyDatasetID, newID)
+		}
+		sc.DifyDatasetID = newID
+		return nil
+	})
+	if err != nil {
 		logger.Log.Errorf("Failed to update site %s with new dataset ID: %v", siteID, err)
 		os.Exit(1)
 	}
 
 	fmt.Printf("New dataset created: %s\n", newID)
 }
+
+// rebuildMapping recovers the site's post-to-document mapping from the document names
+// in its dataset and reports what could not be matched.
+func rebuildMapping(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient, siteID string, wait bool) {
+	var lockWait time.Duration
+	if wait {
+		lockWait = cliLockWait
+	}
+	report, err := syncer.RebuildMapping(ctx, sm, difyCli, siteID, lockWait)
+	if errors.Is(err, sites.ErrSyncInProgress) {
+		fmt.Printf("%v. Retry later or pass --wait.\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		logger.Log.Errorf("Failed to rebuild mapping of site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Dataset %s has %d document(s). Mapped %d post(s) by document name", report.DatasetID, report.Documents, report.Mapped)
+	if report.Kept > 0 {
+		fmt.Printf(", kept %d earlier mapping(s) for documents named without a post ID", report.Kept)
+	}
+	fmt.Println(".")
+	if report.Dropped > 0 {
+		fmt.Printf("Dropped %d earlier mapping(s) whose documents no longer exist.\n", report.Dropped)
+	}
+	if report.OtherSites > 0 {
+		fmt.Printf("Skipped %d document(s) belonging to other sites that share the dataset.\n", report.OtherSites)
+	}
+
+	if len(report.Duplicates) > 0 {
+		postIDs := make([]int, 0, len(report.Duplicates))
+		for postID := range report.Duplicates {
+			postIDs = append(postIDs, postID)
+		}
+		sort.Ints(postIDs)
+		fmt.Printf("Posts with more than one document (%d); only the mapped one is kept up to date, delete the others in Dify:\n", len(postIDs))
+		for _, postID := range postIDs {
+			fmt.Printf("- post %d: mapped %s, duplicates %s\n", postID, report.Mapping[postID], strings.Join(report.Duplicates[postID], ", "))
+		}
+	}
+	if len(report.Unmatched) > 0 {
+		fmt.Printf("Documents not matching any post (%d); they are left alone and will not be updated:\n", len(report.Unmatched))
+		for _, doc := range report.Unmatched {
+			fmt.Printf("- %s %q\n", doc.ID, doc.Name)
+		}
+	}
+}
+
+// addSelfHostedSite registers a self-hosted WordPress site that is accessed with an
+// application password, creating a Dify dataset for it. For a site that is already
+// registered only the credentials (and post types, if given) are replaced, keeping its
+// dataset, mapping, schedule and webhook secret.
+func addSelfHostedSite(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient, blogURL, username, appPassword, postTypesStr string) {
+	u, err := url.Parse(blogURL)
+	if err != nil || u.Host == "" {
+		fmt.Printf("Invalid blog_url: %s\n", blogURL)
+		os.Exit(1)
+	}
+	// Self-hosted sites have no WordPress.com blog ID, so the host and path identify them.
+	siteID := strings.TrimRight(u.Host+u.Path, "/")
+
+	wp := wpcom.NewSelfHostedClient(blogURL, username, appPassword)
+	if err := wp.VerifyCredentials(ctx); err != nil {
+		logger.Log.Errorf("Failed to verify credentials for %s: %v", blogURL, err)
+		os.Exit(1)
+	}
+	var postTypes []string
+	if postTypesStr != "" {
+		postTypes = strings.Split(postTypesStr, ",")
+	}
+
+	lock, err := sm.LockSite(ctx, siteID, triggerCLI+":add-selfhosted-site", time.Minute)
+	if err != nil {
+		fmt.Printf("Cannot add site %s: %v\n", siteID, err)
+		os.Exit(1)
+	}
+	defer lock.Unlock()
+
+	sc, err := sm.GetSite(ctx, siteID)
+	switch {
+	case err == nil:
+		if sc.Source() != sites.SourceSelfHosted {
+			fmt.Printf("Site %s is already registered as a %s site; remove it first to add it as a self-hosted site.\n", siteID, sc.Source())
+			os.Exit(1)
+		}
+		sc.Username = username
+		sc.AccessToken = appPassword
+		sc.Status = sites.StatusActive
+		if len(postTypes) > 0 {
+			sc.PostTypes = postTypes
+		}
+		if err := sm.UpdateSiteLocked(ctx, sc, lock); err != nil {
+			logger.Log.Errorf("Failed to update site %s: %v", siteID, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Self-hosted site %s is already registered; credentials updated (dataset: %s)\n", siteID, sc.DifyDatasetID)
+		return
+	case !errors.Is(err, sites.ErrSiteNotFound):
+		// Anything but a missing record must not be mistaken for a new site, or the
+		// existing config would be overwritten and its dataset orphaned.
+		logger.Log.Errorf("Failed to look up site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+
+	datasetID, err := difyCli.CreateDataset(ctx, blogURL)
+	if err != nil {
+		logger.Log.Errorf("Failed to create Dify dataset for %s: %v", blogURL, err)
+		os.Exit(1)
+	}
+
+	sc = &sites.SiteConfig{
+		SiteID:        siteID,
+		BlogURL:       wp.BaseURL,
+		AccessToken:   appPassword,
+		Username:      username,
+		SourceType:    sites.SourceSelfHosted,
+		DifyDatasetID: datasetID,
+		PostTypes:     postTypes,
+	}
+	if err := sm.AddSite(ctx, sc); err != nil {
+		logger.Log.Errorf("Failed to store site config for %s: %v", siteID, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Self-hosted site added: %s (site_id: %s, dataset: %s)\n", wp.BaseURL, siteID, datasetID)
+}
+
+// addFeedSite registers a public site whose pages are discovered through a sitemap or
+// RSS/Atom feed, creating a Dify dataset for it. No credentials are stored, so there
+// is nothing to update for a site that is already registered and it is refused.
+func addFeedSite(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient, feedURL string) {
+	u, err := url.Parse(feedURL)
+	if err != nil || u.Host == "" {
+		fmt.Printf("Invalid feed URL: %s\n", feedURL)
+		os.Exit(1)
+	}
+	siteID := strings.TrimRight(u.Host+u.Path, "/")
+	blogURL := u.Scheme + "://" + u.Host
+
+	lock, err := sm.LockSite(ctx, siteID, triggerCLI+":add-feed-site", time.Minute)
+	if err != nil {
+		fmt.Printf("Cannot add site %s: %v\n", siteID, err)
+		os.Exit(1)
+	}
+	defer lock.Unlock()
+
+	existing, err := sm.GetSite(ctx, siteID)
+	switch {
+	case err == nil:
+		fmt.Printf("Site %s is already registered (dataset: %s); remove it first to add it again.\n", siteID, existing.DifyDatasetID)
+		os.Exit(1)
+	case !errors.Is(err, sites.ErrSiteNotFound):
+		logger.Log.Errorf("Failed to look up site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+
+	datasetID, err := difyCli.CreateDataset(ctx, blogURL)
+	if err != nil {
+		logger.Log.Errorf("Failed to create Dify dataset for %s: %v", blogURL, err)
+		os.Exit(1)
+	}
+
+	sc := &sites.SiteConfig{
+		SiteID:        siteID,
+		BlogURL:       blogURL,
+		SourceType:    sites.SourceFeed,
+		FeedURL:       feedURL,
+		DifyDatasetID: datasetID,
+	}
+	if err := sm.AddSite(ctx, sc); err != nil {
+		logger.Log.Errorf("Failed to store site config for %s: %v", siteID, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Feed site added: %s (site_id: %s, dataset: %s)\n", feedURL, siteID, datasetID)
+}
+
+// importWXR syncs the published posts of a WXR export into Dify. The mapping is stored
+// under the export's blog, so re-importing a newer export only updates changed posts.
+func importWXR(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient, path, datasetID, postTypesStr string, wait bool, sinks []sink.Sink) {
+	var postTypes []string
+	if postTypesStr != "" {
+		postTypes = strings.Split(postTypesStr, ",")
+	}
+
+	// Parse once without type filtering just to learn which site the export belongs to.
+	probe, err := wxr.Open(path, nil)
+	if err != nil {
+		logger.Log.Errorf("Failed to read WXR export: %v", err)
+		os.Exit(1)
+	}
+	siteID, err := probe.SiteID()
+	if err != nil {
+		logger.Log.Errorf("Failed to identify site in WXR export: %v", err)
+		os.Exit(1)
+	}
+	// The blog may already be registered under another ID, e.g. its numeric
+	// WordPress.com blog ID; import into that site instead of creating a second one.
+	existing, err := sm.FindSiteByBlogURL(ctx, probe.BlogURL)
+	switch {
+	case err == nil:
+		siteID = existing.SiteID
+		logger.Log.Infof("Export of %s belongs to registered site %s", probe.BlogURL, siteID)
+	case !errors.Is(err, sites.ErrSiteNotFound):
+		logger.Log.Errorf("Failed to look up site for %s: %v", probe.BlogURL, err)
+		os.Exit(1)
+	}
+
+	var lockWait time.Duration
+	if wait {
+		lockWait = cliLockWait
+	}
+	lock, err := sm.LockSite(ctx, siteID, triggerCLI+":import-wxr", lockWait)
+	if err != nil {
+		fmt.Printf("Cannot import into site %s: %v\n", siteID, err)
+		os.Exit(1)
+	}
+	defer lock.Unlock()
+	ctx = lock.Context()
+
+	sc, err := sm.GetSite(ctx, siteID)
+	if err != nil && !errors.Is(err, sites.ErrSiteNotFound) {
+		logger.Log.Errorf("Failed to load site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+	if err != nil {
+		if datasetID == "" {
+			fmt.Printf("Site %s is not registered yet; --dataset is required.\n", siteID)
+			os.Exit(1)
+		}
+		sc = &sites.SiteConfig{
+			SiteID:        siteID,
+			BlogURL:       probe.BlogURL,
+			SourceType:    sites.SourceWXR,
+			DifyDatasetID: datasetID,
+			PostTypes:     postTypes,
+		}
+		if err := sm.AddSite(ctx, sc); err != nil {
+			logger.Log.Errorf("Failed to store site config for %s: %v", siteID, err)
+			os.Exit(1)
+		}
+	} else if datasetID != "" && datasetID != sc.DifyDatasetID {
+		fmt.Printf("Site %s is already mapped to dataset %s, not %s.\n", siteID, sc.DifyDatasetID, datasetID)
+		os.Exit(1)
+	}
+	if len(postTypes) == 0 {
+		postTypes = sc.PostTypes
+	}
+
+	src, err := wxr.Open(path, postTypes)
+	if err != nil {
+		logger.Log.Errorf("Failed to read WXR export: %v", err)
+		os.Exit(1)
+	}
+	if err := syncer.Run(ctx, sm, sc, src, difyCli, sinks...); err != nil {
+		logger.Log.Errorf("Failed to import WXR export for site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+	if err := sm.UpdateSiteLocked(ctx, sc, lock); err != nil {
+		logger.Log.Errorf("Failed to update site %s after import: %v", siteID, err)
+		os.Exit(1)
+	}
+	fmt.Printf("WXR export imported into site %s (dataset: %s).\n", siteID, sc.DifyDatasetID)
+}
+
+// exportSite fetches every post of a site from its source and writes it to dir as markdown
+// files plus a manifest, without touching Dify or the stored sync state.
+func exportSite(ctx context.Context, sm *sites.Manager, siteID, dir string) {
+	sc, err := sm.GetSite(ctx, siteID)
+	if err != nil {
+		logger.Log.Errorf("Failed to get site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+
+	// Work on a copy so the full fetch neither advances nor clobbers the real sync state.
+	// Dify is not touched, so no mapping is written and no Manager is needed.
+	exportCfg := *sc
+	exportCfg.LastSyncTime = time.Time{}
+	exportCfg.PostDocMapping = make(map[int]string)
+	exportCfg.FeedETags = nil
+
+	src, err := syncer.NewSource(&exportCfg, sm.WPComAPIBase)
+	if err != nil {
+		logger.Log.Errorf("Failed to export site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+	if err := syncer.Run(ctx, nil, &exportCfg, src, nil, sink.NewFilesystem(dir)); err != nil {
+		logger.Log.Errorf("Failed to export site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Site %s exported to %s.\n", siteID, dir)
+}
+
+// setWebhookSecret stores the HMAC secret used to verify webhook notifications for a site,
+// generating a random one when none is given.
+func setWebhookSecret(ctx context.Context, sm *sites.Manager, siteID, secret string) {
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			logger.Log.Errorf("Failed to generate webhook secret: %v", err)
+			os.Exit(1)
+		}
+		secret = hex.EncodeToString(b)
+	}
+	_, err := sm.ModifySite(ctx, siteID, triggerCLI+":set-webhook-secret", cliLockWait, func(sc *sites.SiteConfig) error {
+		sc.WebhookSecret = secret
+		return nil
+	})
+	if err != nil {
+		logger.Log.Errorf("Failed to update site %s with webhook secret: %v", siteID, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Webhook secret for site %s: %s\n", siteID, secret)
+}
+
+// setSchedule stores the scheduler's cron expression or interval for a site; "off" clears it.
+func setSchedule(ctx context.Context, sm *sites.Manager, siteID, spec string) {
+	if spec == "off" {
+		spec = ""
+	} else if _, err := scheduler.ParseSchedule(spec); err != nil {
+		fmt.Printf("Invalid schedule %q: %v\n", spec, err)
+		os.Exit(1)
+	}
+	_, err := sm.ModifySite(ctx, siteID, triggerCLI+":set-schedule", cliLockWait, func(sc *sites.SiteConfig) error {
+		sc.SyncSchedule = spec
+		return nil
+	})
+	if err != nil {
+		logger.Log.Errorf("Failed to update site %s after setting schedule: %v", siteID, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Sync schedule for site %s set to: %q\n", siteID, spec)
+}
+
+// setPaused pauses or resumes scheduled syncs of a site.
+func setPaused(ctx context.Context, sm *sites.Manager, siteID string, paused bool) {
+	_, err := sm.ModifySite(ctx, siteID, triggerCLI+":pause", cliLockWait, func(sc *sites.SiteConfig) error {
+		sc.SyncPaused = paused
+		return nil
+	})
+	if err != nil {
+		logger.Log.Errorf("Failed to update site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+	if paused {
+		fmt.Printf("Scheduled syncs for site %s paused.\n", siteID)
+	} else {
+		fmt.Printf("Scheduled syncs for site %s resumed.\n", siteID)
+	}
+}
+
+// listSyncRuns prints the site's most recent sync runs and recovery events.
+func listSyncRuns(ctx context.Context, sm *sites.Manager, siteID string) {
+	runs, err := sm.ListSyncRuns(ctx, siteID, 20)
+	if err != nil {
+		logger.Log.Errorf("Failed to list sync runs for site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+	if len(runs) == 0 {
+		fmt.Println("No sync runs recorded.")
+	}
+	for _, r := range runs {
+		fmt.Printf("- %s %s (%s, took %s) %s\n",
+			r.StartedAt.Format(time.RFC3339), r.Status, r.Trigger, r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond), r.Error)
+	}
+
+	recoveries, err := sm.ListRecoveries(ctx, siteID, 20)
+	if err != nil {
+		logger.Log.Errorf("Failed to list recoveries for site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+	if len(recoveries) == 0 {
+		return
+	}
+	fmt.Println("Recovered from deletions in Dify:")
+	for _, r := range recoveries {
+		switch r.Kind {
+		case sites.RecoveryDocumentRecreated:
+			fmt.Printf("- %s document %s of post %d was missing, recreated as %s\n", r.At.Format(time.RFC3339), r.OldID, r.PostID, r.NewID)
+		case sites.RecoveryDatasetRecreated:
+			fmt.Printf("- %s dataset %s was missing, replaced by %s\n", r.At.Format(time.RFC3339), r.OldID, r.NewID)
+		}
+	}
+}
+
+func migrateSites(ctx context.Context, sm *sites.Manager) {
+	migrated, err := sm.Migrate(ctx)
+	if err != nil {
+		logger.Log.Errorf("Failed to migrate sites after %d site(s): %v", migrated, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Migrated %d site(s) to schema version %d.\n", migrated, sites.CurrentSchemaVersion)
+}
+
+// removeSite deletes a site and all of its stored state after asking for confirmation.
+// cleanup decides what happens to its Dify dataset.
+func removeSite(ctx context.Context, sm *sites.Manager, difyCli *dify.DifyClient, siteID, cleanup string, yes bool) {
+	sc, err := sm.GetSite(ctx, siteID)
+	if err != nil {
+		logger.Log.Errorf("Failed to get site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+
+	if !yes {
+		fmt.Printf("This removes site %s (%s) and forgets its credentials and post mapping.\n", siteID, sc.BlogURL)
+		switch cleanup {
+		case syncer.CleanupDataset:
+			fmt.Printf("Dify dataset %s will be DELETED with all of its documents.\n", sc.DifyDatasetID)
+		case syncer.CleanupDocuments:
+			fmt.Printf("The %d documents this tool created in dataset %s will be deleted.\n", len(sc.PostDocMapping), sc.DifyDatasetID)
+		default:
+			fmt.Printf("Dify dataset %s will be kept.\n", sc.DifyDatasetID)
+		}
+		fmt.Print("Continue? (y/n): ")
+		var input string
+		fmt.Scanln(&input)
+		if input != "y" && input != "Y" {
+			fmt.Println("Aborting site removal.")
+			return
+		}
+	}
+
+	err = syncer.RemoveSite(ctx, sm, difyCli, siteID, syncer.RemoveOptions{
+		Cleanup:  cleanup,
+		LockWait: cliLockWait,
+	})
+	if err != nil {
+		logger.Log.Errorf("Failed to remove site %s: %v", siteID, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Site %s removed.\n", siteID)
+	if sc.Source() == sites.SourceWPCom || sc.Source() == sites.SourceSelfHosted {
+		fmt.Println("The stored credentials were deleted; revoke the app's access on the WordPress side to invalidate them too.")
+	}
+}
+
+// rotateKeys re-encrypts every stored access token that is in plaintext or encrypted
+// with an older key, so the older key can be removed from ENCRYPTION_KEYS afterwards.
+func rotateKeys(ctx context.Context, sm *sites.Manager) {
+	rewritten, err := sm.ReencryptTokens(ctx, cliLockWait)
+	if err != nil {
+		logger.Log.Errorf("Failed to rotate keys after %d site(s): %v", rewritten, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Re-encrypted access tokens of %d site(s).\n", rewritten)
+}
+
+// migrateStore copies all sites from one storage backend to another, e.g. from
+// "redis" to "bolt:/data/sync.db". It opens the stores itself so that neither has
+// to be the configured STORE.
+func migrateStore(ctx context.Context, cfg *config.Config, keys *secrets.Keyring, from, to string) {
+	if from == to {
+		fmt.Println("Source and destination stores are the same.")
+		os.Exit(1)
+	}
+	src, err := backend.OpenSpec(from, cfg)
+	if err != nil {
+		logger.Log.Fatalf("Error opening source store: %v", err)
+	}
+	defer src.Close()
+	dst, err := backend.OpenSpec(to, cfg)
+	if err != nil {
+		logger.Log.Fatalf("Error opening destination store: %v", err)
+	}
+	defer dst.Close()
+
+	copied, err := sites.NewManager(src, keys).CopyTo(ctx, sites.NewManager(dst, keys))
+	if err != nil {
+		logger.Log.Errorf("Failed to migrate store after %d site(s): %v", copied, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Copied %d site(s) from %s to %s.\n", copied, from, to)
+}
+
+// setTokenStatus records the result of checking a site's token: a rejected token marks
+// the site needs_reauth, a valid one clears that mark. Sites whose token changed since
+// it was checked, for example because they were reconnected meanwhile, are left
+// alone. A sync running on the site finds a rejected token itself, so the lock is only
+// waited for briefly.
+func setTokenStatus(ctx context.Context, sm *sites.Manager, siteID, checkedToken string, valid bool) error {
+	_, err := sm.ModifySite(ctx, siteID, triggerCLI+":check-tokens", time.Minute, func(sc *sites.SiteConfig) error {
+		if sc.AccessToken != checkedToken || sc.Status == sites.StatusDisconnected {
+			return nil
+		}
+		if !valid {
+			sc.Status = sites.StatusNeedsReauth
+		} else if sc.Status == sites.StatusNeedsReauth {
+			sc.Status = sites.StatusActive
+		}
+		return nil
+	})
+	return err
+}
+
+// checkTokens validates the token of every WordPress.com site with the token-info
+// endpoint, marks sites with rejected tokens as needs_reauth, clears that mark from
+// sites whose token is valid and prints a re-auth link for each site that needs one.
+// Sites sharing a global token are checked once and get one link that reconnects all
+// of them. It exits non-zero if any site needs re-auth.
+func checkTokens(ctx context.Context, cfg *config.Config, store storage.Store, sm *sites.Manager) {
+	allSites, err := sm.ListSites(ctx)
+	if err != nil {
+		logger.Log.Errorf("Failed to list sites: %v", err)
+		os.Exit(1)
+	}
+	om := newOAuthManager(cfg)
+	states := oauth.NewStateStore(store, cfg.ClientSecret)
+
+	sharing := make(map[string][]string) // token ID -> sites using it
+	for _, sc := range allSites {
+		if sc.TokenID != "" {
+			sharing[sc.TokenID] = append(sharing[sc.TokenID], sc.SiteID)
+		}
+	}
+	checked := make(map[string]error) // token ID -> result
+	links := make(map[string]string)  // token ID or site ID -> re-auth link
+
+	affected := 0
+	for _, sc := range allSites {
+		if sc.Source() != sites.SourceWPCom {
+			if sc.Status == sites.StatusNeedsReauth {
+				fmt.Printf("Site %s (%s): credentials rejected; add it again with a new application password.\n", sc.SiteID, sc.BlogURL)
+				affected++
+			}
+			continue
+		}
+		if sc.Status == sites.StatusDisconnected {
+			fmt.Printf("Site %s (%s): disconnected, not checked.\n", sc.SiteID, sc.BlogURL)
+			continue
+		}
+
+		var err error
+		if sc.TokenID == "" {
+			err = om.CheckToken(sc.AccessToken, sc.SiteID)
+		} else if cached, ok := checked[sc.TokenID]; ok {
+			err = cached
+		} else {
+			err = om.CheckToken(sc.AccessToken, sc.SiteID)
+			checked[sc.TokenID] = err
+		}
+		switch {
+		case errors.Is(err, oauth.ErrInvalidToken):
+			fmt.Printf("Site %s (%s): token rejected: %v\n", sc.SiteID, sc.BlogURL, err)
+			if sc.Status != sites.StatusNeedsReauth {
+				if err := setTokenStatus(ctx, sm, sc.SiteID, sc.AccessToken, false); err != nil {
+					logger.Log.Errorf("Failed to mark site %s as %s: %v", sc.SiteID, sites.StatusNeedsReauth, err)
+				}
+			}
+		case err != nil:
+			fmt.Printf("Site %s (%s): could not check token: %v\n", sc.SiteID, sc.BlogURL, err)
+			continue
+		case sc.Status == sites.StatusNeedsReauth:
+			if err := setTokenStatus(ctx, sm, sc.SiteID, sc.AccessToken, true); err != nil {
+				logger.Log.Errorf("Failed to clear %s of site %s: %v", sites.StatusNeedsReauth, sc.SiteID, err)
+				continue
+			}
+			fmt.Printf("Site %s (%s): token is valid again; cleared %s.\n", sc.SiteID, sc.BlogURL, sites.StatusNeedsReauth)
+			continue
+		default:
+			fmt.Printf("Site %s (%s): OK\n", sc.SiteID, sc.BlogURL)
+			continue
+		}
+		affected++
+
+		intent, linkKey := &oauth.Intent{}, sc.SiteID
+		if sc.TokenID != "" {
+			intent, linkKey = &oauth.Intent{Global: true, SiteIDs: sharing[sc.TokenID]}, sc.TokenID
+		}
+		if _, ok := links[linkKey]; !ok {
+			state, err := states.Create(ctx, intent)
+			if err != nil {
+				logger.Log.Errorf("Failed to store OAuth state: %v", err)
+				os.Exit(1)
+			}
+			links[linkKey] = om.BeginURL(state)
+		}
+		fmt.Printf("  Re-authorize: %s\n", links[linkKey])
+	}
+
+	if affected > 0 {
+		fmt.Printf("%d site(s) need re-authorization. Links are valid for 15 minutes and one use each; reconnecting keeps each site's dataset and mapping.\n", affected)
+		os.Exit(1)
+	}
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 
+	"dify-wp-sync/internal/admin"
 	"dify-wp-sync/internal/config"
 	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/jobs"
 	"dify-wp-sync/internal/logger"
 	"dify-wp-sync/internal/oauth"
 	"dify-wp-sync/internal/redisstore"
+	"dify-wp-sync/internal/scheduler"
+	"dify-wp-sync/internal/secrets"
+	"dify-wp-sync/internal/sink"
 	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/storage/backend"
+	"dify-wp-sync/internal/webhook"
 )
 
 func main() {
@@ -19,20 +27,70 @@ func main() {
 		logger.Log.Fatalf("Error loading config: %v", err)
 	}
 
-	store := redisstore.New(cfg.RedisAddr, cfg.RedisPwd, cfg.RedisDB)
-	sitesMgr := sites.NewManager(store)
+	keys, err := secrets.LoadKeys(cfg.EncryptionKeys, cfg.EncryptionKeysFile)
+	if err != nil {
+		logger.Log.Fatalf("Error loading encryption keys: %v", err)
+	}
+	if keys == nil {
+		logger.Log.Warnf("ENCRYPTION_KEYS is not set; access tokens are stored in plaintext")
+	}
+
+	store, err := backend.Open(cfg)
+	if err != nil {
+		logger.Log.Fatalf("Error opening %s store: %v", cfg.Store, err)
+	}
+	sitesMgr := sites.NewManager(store, keys)
+	sitesMgr.WPComAPIBase = cfg.WPComAPIBase
 	difyClient := dify.NewDifyClient(cfg.DifyToken, cfg.DifyBaseURL)
-	oauthManager := oauth.NewOAuthManager(cfg.ClientID, cfg.ClientSecret, cfg.RedirectURI)
+	oauthManager := oauth.NewOAuthManager(cfg.ClientID, cfg.ClientSecret, cfg.RedirectURI,
+		oauth.WithOAuthBase(cfg.WPComOAuthBase), oauth.WithAPIBase(cfg.WPComAPIBase))
 	authHandler := &oauth.AuthHandler{
 		Oauth:    oauthManager,
 		SitesMgr: sitesMgr,
 		DifyCli:  difyClient,
+		States:   oauth.NewStateStore(store, cfg.ClientSecret),
+
+		AdminToken: cfg.AdminAPIToken,
+	}
+
+	var sinks []sink.Sink
+	if cfg.ExportDir != "" {
+		sinks = append(sinks, sink.NewFilesystem(cfg.ExportDir))
+	}
+	var queue jobs.Queue = jobs.NewLocalQueue(jobs.NewStore(store), sitesMgr, difyClient, sinks...)
+	if cfg.JobQueue == "redis" {
+		rs, ok := store.(*redisstore.RedisStore)
+		if !ok {
+			logger.Log.Fatalf("JOB_QUEUE=redis requires STORE=redis")
+		}
+		queue = jobs.NewStreamQueue(jobs.NewStore(store), rs)
+	}
+	webhookHandler := webhook.NewHandler(sitesMgr, store, queue)
+	if cfg.SchedulerEnabled {
+		sched := scheduler.New(sitesMgr, difyClient, cfg.SchedulerConcurrency, cfg.DefaultSyncSchedule, cfg.SchedulerJitter, sinks...)
+		go sched.Run(context.Background())
 	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "System status: OK")
 	})
+	http.HandleFunc("/oauth/start", authHandler.HandleStart)
+	http.HandleFunc("/oauth/begin", authHandler.HandleBegin)
 	http.HandleFunc("/oauth/callback", authHandler.HandleOAuthCallback)
+	http.HandleFunc("/oauth/select", authHandler.HandleSelect)
+	http.HandleFunc("/oauth/result", authHandler.HandleResult)
+	http.HandleFunc("/webhooks/wpcom", webhookHandler.HandleWPCom)
+	if cfg.AdminAPIToken != "" {
+		adminAPI := &admin.API{
+			SitesMgr: sitesMgr,
+			DifyCli:  difyClient,
+			Jobs:     queue,
+			Token:    cfg.AdminAPIToken,
+		}
+		http.Handle("/admin/", adminAPI.Handler())
+	} else {
+		logger.Log.Warnf("ADMIN_API_TOKEN is not set; the admin API and /oauth/start are disabled")
+	}
 
 	logger.Log.Infof("Starting server on port %s", cfg.Port)
 	if err := http.ListenAndServe(":"+cfg.Port, nil); err != nil {
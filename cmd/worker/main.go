@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"dify-wp-sync/internal/config"
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/jobs"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/redisstore"
+	"dify-wp-sync/internal/secrets"
+	"dify-wp-sync/internal/sink"
+	"dify-wp-sync/internal/sites"
+)
+
+func main() {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		logger.Log.Fatalf("Error loading config: %v", err)
+	}
+
+	keys, err := secrets.LoadKeys(cfg.EncryptionKeys, cfg.EncryptionKeysFile)
+	if err != nil {
+		logger.Log.Fatalf("Error loading encryption keys: %v", err)
+	}
+	if keys == nil {
+		logger.Log.Warnf("ENCRYPTION_KEYS is not set; access tokens are stored in plaintext")
+	}
+
+	// Workers consume the Redis job stream, so they always use the Redis store.
+	if cfg.Store != "redis" {
+		logger.Log.Fatalf("The worker requires STORE=redis (got %q)", cfg.Store)
+	}
+	store := redisstore.New(cfg.RedisAddr, cfg.RedisPwd, cfg.RedisDB)
+	sitesMgr := sites.NewManager(store, keys)
+	sitesMgr.WPComAPIBase = cfg.WPComAPIBase
+	difyClient := dify.NewDifyClient(cfg.DifyToken, cfg.DifyBaseURL)
+	jobStore := jobs.NewStore(store)
+
+	var sinks []sink.Sink
+	if cfg.ExportDir != "" {
+		sinks = append(sinks, sink.NewFilesystem(cfg.ExportDir))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hostname, _ := os.Hostname()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.WorkerConcurrency; i++ {
+		name := fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), i)
+		w := jobs.NewWorker(name, cfg.JobVisibilityTimeout, jobStore, store, sitesMgr, difyClient, sinks...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Run(ctx); err != nil {
+				logger.Log.Errorf("Worker %s exited: %v", name, err)
+			}
+		}()
+	}
+
+	logger.Log.Infof("Started %d workers", cfg.WorkerConcurrency)
+	wg.Wait()
+}
@@ -4,12 +4,14 @@ go 1.23
 
 require (
 	github.com/JohannesKaufmann/html-to-markdown v1.4.1
+	github.com/PuerkitoBio/goquery v1.8.1
 	github.com/redis/go-redis/v9 v9.0.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.9.3
+	go.etcd.io/bbolt v1.3.10
 )
 
 require (
-	github.com/PuerkitoBio/goquery v1.8.1 // indirect
 	github.com/andybalholm/cascadia v1.3.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
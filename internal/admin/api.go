@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/jobs"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sites"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// API serves the JSON admin API under /admin/. Every endpoint except the OpenAPI
+// document requires "Authorization: Bearer <token>".
+type API struct {
+	SitesMgr *sites.Manager
+	DifyCli  *dify.DifyClient
+	Jobs     jobs.Queue
+	Token    string
+}
+
+// Handler returns the admin API's routes wrapped in token authentication.
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/sites", a.listSites)
+	mux.HandleFunc("GET /admin/sites/{id}", a.getSite)
+	mux.HandleFunc("PATCH /admin/sites/{id}", a.updateSite)
+	mux.HandleFunc("DELETE /admin/sites/{id}", a.removeSite)
+	mux.HandleFunc("POST /admin/sites/{id}/sync", a.startJob(jobs.KindSync))
+	mux.HandleFunc("POST /admin/sites/{id}/force-sync", a.startJob(jobs.KindForceSync))
+	mux.HandleFunc("POST /admin/sites/{id}/disconnect", a.disconnectSite)
+	mux.HandleFunc("GET /admin/sites/{id}/runs", a.listRuns)
+	mux.HandleFunc("GET /admin/sites/{id}/recoveries", a.listRecoveries)
+	mux.HandleFunc("GET /admin/jobs/{id}", a.getJob)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/admin/openapi.yaml" {
+			w.Header().Set("Content-Type", "application/yaml")
+			w.Write(openAPISpec)
+			return
+		}
+		if !a.authorized(r) {
+			writeError(w, http.StatusUnauthorized, "invalid or missing admin token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (a *API) authorized(r *http.Request) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return a.Token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(a.Token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Log.Warnf("Failed to write admin API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,282 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"dify-wp-sync/internal/jobs"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/scheduler"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/syncer"
+)
+
+// adminLockWait is how long a config change waits for the site's lock; a sync in
+// progress usually holds it for longer, and the change is then refused with 409.
+const adminLockWait = 10 * time.Second
+
+// siteView is the admin representation of a site. Credentials and the full
+// post mapping are never exposed.
+type siteView struct {
+	SiteID        string    `json:"site_id"`
+	BlogURL       string    `json:"blog_url"`
+	SourceType    string    `json:"source_type"`
+	Status        string    `json:"status"`
+	DifyDatasetID string    `json:"dify_dataset_id"`
+	LastSyncTime  time.Time `json:"last_sync_time"`
+	PostTypes     []string  `json:"post_types"`
+	DocumentCount int       `json:"document_count"`
+	SyncSchedule  string    `json:"sync_schedule"`
+	SyncPaused    bool      `json:"sync_paused"`
+}
+
+func newSiteView(sc *sites.SiteConfig) siteView {
+	status := sc.Status
+	if status == "" {
+		status = sites.StatusActive
+	}
+	postTypes := sc.PostTypes
+	if postTypes == nil {
+		postTypes = []string{}
+	}
+	return siteView{
+		SiteID:        sc.SiteID,
+		BlogURL:       sc.BlogURL,
+		SourceType:    sc.Source(),
+		Status:        status,
+		DifyDatasetID: sc.DifyDatasetID,
+		LastSyncTime:  sc.LastSyncTime,
+		PostTypes:     postTypes,
+		DocumentCount: len(sc.PostDocMapping),
+		SyncSchedule:  sc.SyncSchedule,
+		SyncPaused:    sc.SyncPaused,
+	}
+}
+
+// siteUpdate holds the fields PATCH may change; nil fields are left untouched.
+type siteUpdate struct {
+	PostTypes    *[]string `json:"post_types"`
+	SyncSchedule *string   `json:"sync_schedule"`
+	SyncPaused   *bool     `json:"sync_paused"`
+}
+
+func (a *API) listSites(w http.ResponseWriter, r *http.Request) {
+	allSites, err := a.SitesMgr.ListSites(r.Context())
+	if err != nil {
+		logger.Log.Errorf("Admin API failed to list sites: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to list sites")
+		return
+	}
+	views := make([]siteView, 0, len(allSites))
+	for _, sc := range allSites {
+		views = append(views, newSiteView(sc))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sites": views})
+}
+
+// loadSite fetches the site named in the path, writing a 404 if it does not exist.
+func (a *API) loadSite(w http.ResponseWriter, r *http.Request) (*sites.SiteConfig, bool) {
+	sc, err := a.SitesMgr.GetSite(r.Context(), r.PathValue("id"))
+	if errors.Is(err, sites.ErrSiteNotFound) {
+		writeError(w, http.StatusNotFound, "site not found")
+		return nil, false
+	}
+	if err != nil {
+		logger.Log.Errorf("Admin API failed to load site %s: %v", r.PathValue("id"), err)
+		writeError(w, http.StatusInternalServerError, "failed to load site")
+		return nil, false
+	}
+	return sc, true
+}
+
+func (a *API) getSite(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.loadSite(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newSiteView(sc))
+}
+
+func (a *API) updateSite(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.loadSite(w, r)
+	if !ok {
+		return
+	}
+
+	var upd siteUpdate
+	if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	var postTypes []string
+	if upd.PostTypes != nil {
+		for _, t := range *upd.PostTypes {
+			if t = strings.TrimSpace(t); t != "" {
+				postTypes = append(postTypes, t)
+			}
+		}
+	}
+	if upd.SyncSchedule != nil && *upd.SyncSchedule != "" {
+		if _, err := scheduler.ParseSchedule(*upd.SyncSchedule); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid sync_schedule: "+err.Error())
+			return
+		}
+	}
+
+	sc, ok = a.modifySite(w, r, sc.SiteID, "update", func(sc *sites.SiteConfig) error {
+		if upd.PostTypes != nil {
+			sc.PostTypes = postTypes
+		}
+		if upd.SyncSchedule != nil {
+			sc.SyncSchedule = *upd.SyncSchedule
+		}
+		if upd.SyncPaused != nil {
+			sc.SyncPaused = *upd.SyncPaused
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newSiteView(sc))
+}
+
+// modifySite applies fn to the site under its lock, writing the error response itself
+// when that fails. A sync holding the lock for longer than adminLockWait yields 409.
+func (a *API) modifySite(w http.ResponseWriter, r *http.Request, siteID, action string, fn func(sc *sites.SiteConfig) error) (*sites.SiteConfig, bool) {
+	sc, err := a.SitesMgr.ModifySite(r.Context(), siteID, "admin:"+action, adminLockWait, fn)
+	switch {
+	case err == nil:
+		return sc, true
+	case errors.Is(err, sites.ErrSyncInProgress):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, sites.ErrSiteNotFound):
+		writeError(w, http.StatusNotFound, "site not found")
+	default:
+		logger.Log.Errorf("Admin API failed to %s site %s: %v", action, siteID, err)
+		writeError(w, http.StatusInternalServerError, "failed to "+action+" site")
+	}
+	return nil, false
+}
+
+// startJob returns a handler that queues a job of the given kind for the site.
+func (a *API) startJob(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sc, ok := a.loadSite(w, r)
+		if !ok {
+			return
+		}
+		if !sc.Active() {
+			writeError(w, http.StatusConflict, "site is "+sc.Status)
+			return
+		}
+		job, err := a.Jobs.Enqueue(r.Context(), sc.SiteID, kind)
+		if err != nil {
+			logger.Log.Errorf("Admin API failed to enqueue %s of site %s: %v", kind, sc.SiteID, err)
+			writeError(w, http.StatusInternalServerError, "failed to start job")
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// disconnectSite drops the site's credentials and stops syncing it, keeping its
+// dataset and mapping so it can be reconnected later. A shared token no other site
+// refers to any more is deleted with it.
+func (a *API) disconnectSite(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.loadSite(w, r)
+	if !ok {
+		return
+	}
+	var oldTokenID string
+	sc, ok = a.modifySite(w, r, sc.SiteID, "disconnect", func(sc *sites.SiteConfig) error {
+		oldTokenID = sc.TokenID
+		sc.AccessToken = ""
+		sc.TokenID = ""
+		sc.Status = sites.StatusDisconnected
+		return nil
+	})
+	if !ok {
+		return
+	}
+	if oldTokenID != "" {
+		if err := a.SitesMgr.DeleteUnusedSharedToken(r.Context(), oldTokenID); err != nil {
+			logger.Log.Warnf("Admin API failed to clean up shared token of disconnected site %s: %v", sc.SiteID, err)
+		}
+	}
+	writeJSON(w, http.StatusOK, newSiteView(sc))
+}
+
+// removeSite deletes the site. The dataset query parameter is required and says what
+// happens to its Dify content: "keep", "documents" or "dataset".
+func (a *API) removeSite(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.loadSite(w, r)
+	if !ok {
+		return
+	}
+	cleanup := r.URL.Query().Get("dataset")
+	switch cleanup {
+	case syncer.CleanupKeep, syncer.CleanupDocuments, syncer.CleanupDataset:
+	default:
+		writeError(w, http.StatusBadRequest, `dataset must be "keep", "documents" or "dataset"`)
+		return
+	}
+
+	err := syncer.RemoveSite(r.Context(), a.SitesMgr, a.DifyCli, sc.SiteID, syncer.RemoveOptions{
+		Cleanup: cleanup,
+	})
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, sites.ErrSyncInProgress), errors.Is(err, syncer.ErrDatasetShared):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		logger.Log.Errorf("Admin API failed to remove site %s: %v", sc.SiteID, err)
+		writeError(w, http.StatusInternalServerError, "failed to remove site")
+	}
+}
+
+func (a *API) listRuns(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.loadSite(w, r)
+	if !ok {
+		return
+	}
+	runs, err := a.SitesMgr.ListSyncRuns(r.Context(), sc.SiteID, 50)
+	if err != nil {
+		logger.Log.Errorf("Admin API failed to list runs of site %s: %v", sc.SiteID, err)
+		writeError(w, http.StatusInternalServerError, "failed to list sync runs")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"runs": runs})
+}
+
+func (a *API) listRecoveries(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.loadSite(w, r)
+	if !ok {
+		return
+	}
+	recoveries, err := a.SitesMgr.ListRecoveries(r.Context(), sc.SiteID, 50)
+	if err != nil {
+		logger.Log.Errorf("Admin API failed to list recoveries of site %s: %v", sc.SiteID, err)
+		writeError(w, http.StatusInternalServerError, "failed to list recoveries")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"recoveries": recoveries})
+}
+
+func (a *API) getJob(w http.ResponseWriter, r *http.Request) {
+	job, err := a.Jobs.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, jobs.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		logger.Log.Errorf("Admin API failed to load job %s: %v", r.PathValue("id"), err)
+		writeError(w, http.StatusInternalServerError, "failed to load job")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 
 	"dify-wp-sync/internal/logger"
 )
@@ -15,6 +16,19 @@ type Config struct {
 	RedirectURI  string
 	Port         string
 
+	// WordPress.com endpoints; override to go through a proxy or a local stand-in.
+	// Empty uses wpcom.DefaultAPIBase and wpcom.DefaultOAuthBase.
+	WPComAPIBase   string
+	WPComOAuthBase string
+
+	// Storage backend: "redis" (default) or "bolt" (file at StorePath)
+	Store     string
+	StorePath string
+
+	// Access token encryption keys as "<key id>:<base64 key>" entries, first is primary
+	EncryptionKeys     string
+	EncryptionKeysFile string
+
 	// Redis
 	RedisAddr string
 	RedisDB   int
@@ -23,22 +37,72 @@ type Config struct {
 	// Dify
 	DifyToken   string
 	DifyBaseURL string
+
+	// Optional directory that every sync also mirrors documents into
+	ExportDir string
+
+	// Bearer token for the admin API; the API is disabled when empty
+	AdminAPIToken string
+
+	// Job queue: "local" runs jobs inside the server, "redis" hands them to cmd/worker
+	JobQueue             string
+	WorkerConcurrency    int
+	JobVisibilityTimeout time.Duration
+
+	// Scheduler (server only)
+	SchedulerEnabled     bool
+	SchedulerConcurrency int
+	SchedulerJitter      time.Duration
+	DefaultSyncSchedule  string
 }
 
 // LoadConfig loads configuration from environment variables and performs basic validation.
 func LoadConfig() (*Config, error) {
 	db, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
+	schedulerEnabled, _ := strconv.ParseBool(getEnv("SCHEDULER_ENABLED", "true"))
+	schedulerConcurrency, _ := strconv.Atoi(getEnv("SCHEDULER_CONCURRENCY", "2"))
+	schedulerJitter, _ := time.ParseDuration(getEnv("SCHEDULER_JITTER", "1m"))
+	workerConcurrency, _ := strconv.Atoi(getEnv("WORKER_CONCURRENCY", "2"))
+	jobVisibilityTimeout, err := time.ParseDuration(getEnv("JOB_VISIBILITY_TIMEOUT", "10m"))
+	if err != nil || jobVisibilityTimeout <= 0 {
+		jobVisibilityTimeout = 10 * time.Minute
+	}
+	if workerConcurrency < 1 {
+		workerConcurrency = 1
+	}
 
 	cfg := &Config{
 		ClientID:     os.Getenv("WPCOM_CLIENT_ID"),
 		ClientSecret: os.Getenv("WPCOM_CLIENT_SECRET"),
 		RedirectURI:  os.Getenv("WPCOM_REDIRECT_URI"),
 		Port:         getEnv("PORT", "8080"),
-		RedisAddr:    getEnv("REDIS_ADDR", "localhost:6379"),
-		RedisDB:      db,
-		RedisPwd:     os.Getenv("REDIS_PASSWORD"),
-		DifyToken:    os.Getenv("DIFY_API_KEY"),
-		DifyBaseURL:  getEnv("DIFY_BASE_URL", "https://api.dify.ai/v1"),
+
+		WPComAPIBase:   os.Getenv("WPCOM_API_BASE"),
+		WPComOAuthBase: os.Getenv("WPCOM_OAUTH_BASE"),
+
+		Store:     getEnv("STORE", "redis"),
+		StorePath: getEnv("STORE_PATH", "dify-wp-sync.db"),
+		RedisAddr: getEnv("REDIS_ADDR", "localhost:6379"),
+
+		EncryptionKeys:     os.Getenv("ENCRYPTION_KEYS"),
+		EncryptionKeysFile: os.Getenv("ENCRYPTION_KEYS_FILE"),
+
+		RedisDB:     db,
+		RedisPwd:    os.Getenv("REDIS_PASSWORD"),
+		DifyToken:   os.Getenv("DIFY_API_KEY"),
+		DifyBaseURL: getEnv("DIFY_BASE_URL", "https://api.dify.ai/v1"),
+		ExportDir:   os.Getenv("EXPORT_DIR"),
+
+		AdminAPIToken: os.Getenv("ADMIN_API_TOKEN"),
+
+		JobQueue:             getEnv("JOB_QUEUE", "local"),
+		WorkerConcurrency:    workerConcurrency,
+		JobVisibilityTimeout: jobVisibilityTimeout,
+
+		SchedulerEnabled:     schedulerEnabled,
+		SchedulerConcurrency: schedulerConcurrency,
+		SchedulerJitter:      schedulerJitter,
+		DefaultSyncSchedule:  os.Getenv("DEFAULT_SYNC_SCHEDULE"),
 	}
 
 	// Validate critical fields
@@ -2,62 +2,200 @@ package dify
 
 import (
 	"bytes"
+	"context"
+	"dify-wp-sync/internal/logger"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	defaultMaxRetries = 4
+	defaultRetryBase  = 500 * time.Millisecond
+	// maxRetryDelay caps both the backoff and a Retry-After header, so one throttled
+	// call cannot hold a sync for longer than this per attempt.
+	maxRetryDelay = time.Minute
+)
+
 // DifyClient provides methods for interacting with the Dify API.
 type DifyClient struct {
-	token   string
-	baseURL string
-	client  *http.Client
+	token      string
+	baseURL    string
+	client     *http.Client
+	maxRetries int
+	retryBase  time.Duration
 }
 
-func NewDifyClient(token, baseURL string) *DifyClient {
-	return &DifyClient{
-		token:   token,
-		baseURL: baseURL,
-		client:  &http.Client{Timeout: 15 * time.Second},
+// ClientOption customizes a DifyClient.
+type ClientOption func(*DifyClient)
+
+// WithHTTPClient makes the client send its requests through httpClient.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(d *DifyClient) {
+		d.client = httpClient
 	}
 }
 
-// ListDatasets calls GET /datasets?page=PAGE&limit=LIMIT
-// and returns the parsed JSON response.
-func (d *DifyClient) ListDatasets(page, limit int) (*ListDatasetsResponse, error) {
-	url := fmt.Sprintf("%s/datasets?page=%d&limit=%d", d.baseURL, page, limit)
+// WithRetry sets how many times a call answered with 429 or 5xx is retried (see do), and the
+// delay before the first retry. The delay doubles with each retry and is jittered.
+// maxRetries 0 disables retrying.
+func WithRetry(maxRetries int, base time.Duration) ClientOption {
+	return func(d *DifyClient) {
+		d.maxRetries = maxRetries
+		d.retryBase = base
+	}
+}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+func NewDifyClient(token, baseURL string, opts ...ClientOption) *DifyClient {
+	d := &DifyClient{
+		token:      token,
+		baseURL:    baseURL,
+		client:     &http.Client{Timeout: 15 * time.Second},
+		maxRetries: defaultMaxRetries,
+		retryBase:  defaultRetryBase,
 	}
-	req.Header.Set("Authorization", "Bearer "+d.token)
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
 
-	resp, err := d.client.Do(req)
-	if err != nil {
-		return nil, err
+// do sends a request to path under the base URL, JSON-encoding body if it is not nil,
+// and decodes a successful response into out if it is not nil. Responses with 429 are
+// retried with backoff, and so are 5xx responses to idempotent calls; a create that
+// failed with a 5xx may still have been carried out, and repeating it could leave a
+// duplicate. Any other failure status is returned as an *APIError.
+func (d *DifyClient) do(ctx context.Context, op, method, path string, idempotent bool, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s request: %w", op, err)
+		}
+		payload = b
+	}
+
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		if payload != nil {
+			reqBody = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, d.baseURL+path, reqBody)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+d.token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("dify %s: %w", op, err)
+		}
+		if resp.StatusCode < 300 {
+			defer resp.Body.Close()
+			if out == nil {
+				return nil
+			}
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return fmt.Errorf("failed to decode %s response: %w", op, err)
+			}
+			return nil
+		}
+
+		apiErr := readAPIError(op, resp)
+		retryAfter := resp.Header.Get("Retry-After")
+		resp.Body.Close()
+		retryable := apiErr.StatusCode == http.StatusTooManyRequests || (idempotent && apiErr.Temporary())
+		if !retryable || attempt >= d.maxRetries {
+			return apiErr
+		}
+
+		wait := d.backoff(attempt, retryAfter)
+		logger.Log.Warnf("%v; retrying in %s (%d/%d)", apiErr, wait.Round(time.Millisecond), attempt+1, d.maxRetries)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (gave up waiting to retry: %w)", apiErr, ctx.Err())
+		case <-timer.C:
+		}
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("list datasets returned status code %d", resp.StatusCode)
+// backoff returns how long to wait before retry attempt+1: the server's Retry-After
+// if it sent one, otherwise exponential backoff with full jitter.
+func (d *DifyClient) backoff(attempt int, retryAfter string) time.Duration {
+	if wait, ok := parseRetryAfter(retryAfter); ok {
+		return min(wait, maxRetryDelay)
+	}
+	ceiling := min(d.retryBase<<attempt, maxRetryDelay)
+	if ceiling <= 0 {
+		return 0
 	}
+	return time.Duration(rand.Int63n(int64(ceiling))) + 1
+}
 
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// readAPIError builds the APIError for a failed response, keeping Dify's code and
+// message when the body has them and the start of the raw body otherwise.
+func readAPIError(op string, resp *http.Response) *APIError {
+	apiErr := &APIError{Op: op, StatusCode: resp.StatusCode}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var parsed struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(raw, &parsed); err == nil && (parsed.Code != "" || parsed.Message != "") {
+		apiErr.Code = parsed.Code
+		apiErr.Message = parsed.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(raw))
+		if len(apiErr.Message) > 200 {
+			apiErr.Message = apiErr.Message[:200] + "..."
+		}
+	}
+	return apiErr
+}
+
+// ListDatasets calls GET /datasets?page=PAGE&limit=LIMIT
+// and returns the parsed JSON response.
+func (d *DifyClient) ListDatasets(ctx context.Context, page, limit int) (*ListDatasetsResponse, error) {
 	var listResp ListDatasetsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
-		return nil, fmt.Errorf("failed to decode ListDatasets response: %w", err)
+	path := fmt.Sprintf("/datasets?page=%d&limit=%d", page, limit)
+	if err := d.do(ctx, "list datasets", http.MethodGet, path, true, nil, &listResp); err != nil {
+		return nil, err
 	}
 	return &listResp, nil
 }
 
 // DatasetExists enumerates all pages of datasets, checking if datasetID is present.
-func (d *DifyClient) DatasetExists(datasetID string) (bool, error) {
+func (d *DifyClient) DatasetExists(ctx context.Context, datasetID string) (bool, error) {
 	const pageSize = 20
 	page := 1
 
 	for {
-		listResp, err := d.ListDatasets(page, pageSize)
+		listResp, err := d.ListDatasets(ctx, page, pageSize)
 		if err != nil {
 			return false, fmt.Errorf("failed to list datasets (page=%d): %w", page, err)
 		}
@@ -77,95 +215,66 @@ func (d *DifyClient) DatasetExists(datasetID string) (bool, error) {
 }
 
 // CreateDataset calls Dify to create a new (empty) dataset with "only_me" permission.
-func (d *DifyClient) CreateDataset(name string) (string, error) {
+func (d *DifyClient) CreateDataset(ctx context.Context, name string) (string, error) {
 	reqBody := CreateDatasetRequest{
 		Name:       name,
 		Permission: "only_me",
 	}
-	b, _ := json.Marshal(reqBody)
-	req, err := http.NewRequest("POST", d.baseURL+"/datasets", bytes.NewReader(b))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Authorization", "Bearer "+d.token)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := d.client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		return "", fmt.Errorf("failed to create dataset, status %d", resp.StatusCode)
-	}
 	var dsr DatasetResponse
-	if err := json.NewDecoder(resp.Body).Decode(&dsr); err != nil {
+	if err := d.do(ctx, "create dataset", http.MethodPost, "/datasets", false, reqBody, &dsr); err != nil {
 		return "", err
 	}
 	return dsr.ID, nil
 }
 
+// ListDocuments calls GET /datasets/DATASET/documents?page=PAGE&limit=LIMIT and returns
+// one page of the dataset's documents, newest first.
+func (d *DifyClient) ListDocuments(ctx context.Context, datasetID string, page, limit int) (*ListDocumentsResponse, error) {
+	var listResp ListDocumentsResponse
+	path := fmt.Sprintf("/datasets/%s/documents?page=%d&limit=%d", datasetID, page, limit)
+	if err := d.do(ctx, "list documents", http.MethodGet, path, true, nil, &listResp); err != nil {
+		return nil, err
+	}
+	return &listResp, nil
+}
+
 // CreateDocumentByText creates a new document in the specified dataset.
-func (d *DifyClient) CreateDocumentByText(datasetID, name, text string) (string, error) {
+func (d *DifyClient) CreateDocumentByText(ctx context.Context, datasetID, name, text string) (string, error) {
 	reqBody := CreateDocByTextRequest{
 		Name:              name,
 		Text:              text,
 		IndexingTechnique: "high_quality",
 		ProcessRule:       map[string]string{"mode": "automatic"},
 	}
-	b, _ := json.Marshal(reqBody)
-	fullURL := fmt.Sprintf("%s/datasets/%s/document/create-by-text", d.baseURL, datasetID)
-	req, err := http.NewRequest("POST", fullURL, bytes.NewReader(b))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Authorization", "Bearer "+d.token)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := d.client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		return "", fmt.Errorf("failed to create document, status %d", resp.StatusCode)
-	}
 	var dr DocumentResponse
-	if err := json.NewDecoder(resp.Body).Decode(&dr); err != nil {
+	path := fmt.Sprintf("/datasets/%s/document/create-by-text", datasetID)
+	if err := d.do(ctx, "create document", http.MethodPost, path, false, reqBody, &dr); err != nil {
 		return "", err
 	}
 	return dr.Document.ID, nil
 }
 
 // UpdateDocumentByText updates an existing Dify document with new text content.
-func (d *DifyClient) UpdateDocumentByText(datasetID, docID, name, text string) (string, error) {
+func (d *DifyClient) UpdateDocumentByText(ctx context.Context, datasetID, docID, name, text string) (string, error) {
 	reqBody := map[string]string{
 		"name": name,
 		"text": text,
 	}
-	b, _ := json.Marshal(reqBody)
-	fullURL := fmt.Sprintf("%s/datasets/%s/documents/%s/update_by_text", d.baseURL, datasetID, docID)
-	req, err := http.NewRequest("POST", fullURL, bytes.NewReader(b))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Authorization", "Bearer "+d.token)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := d.client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		return "", fmt.Errorf("failed to update document, status %d", resp.StatusCode)
-	}
 	var dr DocumentResponse
-	if err := json.NewDecoder(resp.Body).Decode(&dr); err != nil {
+	path := fmt.Sprintf("/datasets/%s/documents/%s/update_by_text", datasetID, docID)
+	if err := d.do(ctx, "update document", http.MethodPost, path, true, reqBody, &dr); err != nil {
 		return "", err
 	}
 	return dr.Document.ID, nil
 }
+
+// DeleteDocument removes a document from the specified dataset.
+func (d *DifyClient) DeleteDocument(ctx context.Context, datasetID, docID string) error {
+	path := fmt.Sprintf("/datasets/%s/documents/%s", datasetID, docID)
+	return d.do(ctx, "delete document", http.MethodDelete, path, true, nil, nil)
+}
+
+// DeleteDataset removes a dataset together with all of its documents.
+func (d *DifyClient) DeleteDataset(ctx context.Context, datasetID string) error {
+	return d.do(ctx, "delete dataset", http.MethodDelete, "/datasets/"+datasetID, true, nil, nil)
+}
@@ -0,0 +1,89 @@
+package dify
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"dify-wp-sync/internal/difytest"
+)
+
+func newTestClient(t *testing.T) (*DifyClient, *difytest.Server) {
+	t.Helper()
+	srv := difytest.NewServer()
+	t.Cleanup(srv.Close)
+	return NewDifyClient(difytest.APIKey, srv.BaseURL(), WithRetry(4, time.Millisecond)), srv
+}
+
+// countRequests returns how many requests srv got whose "METHOD URI" contains substr.
+func countRequests(srv *difytest.Server, substr string) int {
+	n := 0
+	for _, r := range srv.Requests() {
+		if strings.Contains(r, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCreatesAreNotRetriedOnServerErrors(t *testing.T) {
+	dc, srv := newTestClient(t)
+	ctx := context.Background()
+	datasetID := srv.AddDataset("https://example.com")
+	srv.Inject(difytest.Fault{Method: "POST", Status: 503, Times: 2})
+
+	if _, err := dc.CreateDocumentByText(ctx, datasetID, "Post", "text"); err == nil {
+		t.Fatal("CreateDocumentByText succeeded despite a 503")
+	}
+	if got := countRequests(srv, "/document/create"); got != 1 {
+		t.Errorf("sent %d document creates, want 1: a create answered with 503 may have gone through", got)
+	}
+	if _, err := dc.CreateDataset(ctx, "https://other.example.com"); err == nil {
+		t.Fatal("CreateDataset succeeded despite a 503")
+	}
+	if got := countRequests(srv, "POST /v1/datasets") - countRequests(srv, "/document/create"); got != 1 {
+		t.Errorf("sent %d dataset creates, want 1", got)
+	}
+	if n := len(srv.Datasets()); n != 1 {
+		t.Errorf("fake Dify has %d datasets, want only the one added", n)
+	}
+}
+
+func TestCreatesAreRetriedWhenThrottled(t *testing.T) {
+	dc, srv := newTestClient(t)
+	datasetID := srv.AddDataset("https://example.com")
+	srv.Inject(difytest.Fault{Method: "POST", Path: "/document/create", Status: 429, RetryAfter: "0", Times: 2})
+
+	docID, err := dc.CreateDocumentByText(context.Background(), datasetID, "Post", "text")
+	if err != nil {
+		t.Fatalf("CreateDocumentByText: %v", err)
+	}
+	if got := countRequests(srv, "/document/create"); got != 3 {
+		t.Errorf("sent %d document creates, want 3 (two throttled, one accepted)", got)
+	}
+	if docs := srv.Documents(datasetID); len(docs) != 1 || docs[0].ID != docID {
+		t.Errorf("got documents %v, want only %s", docs, docID)
+	}
+}
+
+func TestUpdatesAreRetriedOnServerErrors(t *testing.T) {
+	dc, srv := newTestClient(t)
+	ctx := context.Background()
+	datasetID := srv.AddDataset("https://example.com")
+	docID, err := dc.CreateDocumentByText(ctx, datasetID, "Post", "v1")
+	if err != nil {
+		t.Fatalf("CreateDocumentByText: %v", err)
+	}
+	srv.Inject(difytest.Fault{Method: "POST", Path: "/update", Status: 503, Times: 2})
+
+	if _, err := dc.UpdateDocumentByText(ctx, datasetID, docID, "Post", "v2"); err != nil {
+		t.Fatalf("UpdateDocumentByText: %v", err)
+	}
+	if got := countRequests(srv, "/update"); got != 3 {
+		t.Errorf("sent %d updates, want 3 (two failed, one accepted)", got)
+	}
+	if doc, _ := srv.Document(datasetID, docID); doc.Text != "v2" || doc.Updates != 1 {
+		t.Errorf("document has text %q after %d updates, want v2 after 1", doc.Text, doc.Updates)
+	}
+}
@@ -0,0 +1,57 @@
+package dify
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error codes Dify puts in the "code" field of its error responses.
+const (
+	CodeNotFound              = "not_found"
+	CodeDatasetNameDuplicate  = "dataset_name_duplicate"
+	CodeProviderQuotaExceeded = "provider_quota_exceeded"
+	CodeInvalidParam          = "invalid_param"
+)
+
+// APIError is a non-2xx response from Dify. Code and Message come from the JSON error
+// body when there is one; Op names the client call that failed.
+type APIError struct {
+	Op         string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("dify %s: status %d", e.Op, e.StatusCode)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// NotFound reports whether the dataset or document the call referred to does not exist.
+func (e *APIError) NotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// QuotaExceeded reports whether Dify refused the call because a plan or model
+// provider limit has been reached. Retrying does not help until the quota is raised.
+func (e *APIError) QuotaExceeded() bool {
+	return e.Code == CodeProviderQuotaExceeded
+}
+
+// Temporary reports whether the same call may succeed if retried later.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IsNotFound reports whether err is an APIError for a missing dataset or document.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.NotFound()
+}
@@ -56,3 +56,23 @@ type DatasetInfo struct {
 	UpdatedBy         string `json:"updated_by"`
 	UpdatedAt         int64  `json:"updated_at"`
 }
+
+// ListDocumentsResponse is the JSON shape returned by GET /datasets/:datasetID/documents.
+type ListDocumentsResponse struct {
+	Data    []DocumentInfo `json:"data"`
+	HasMore bool           `json:"has_more"`
+	Limit   int            `json:"limit"`
+	Total   int            `json:"total"`
+	Page    int            `json:"page"`
+}
+
+// DocumentInfo describes an individual document in the "data" array.
+type DocumentInfo struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	DataSourceType string `json:"data_source_type"`
+	IndexingStatus string `json:"indexing_status"`
+	Enabled        bool   `json:"enabled"`
+	WordCount      int    `json:"word_count"`
+	CreatedAt      int64  `json:"created_at"`
+}
@@ -0,0 +1,474 @@
+// Package difytest runs an in-process stand-in for the Dify knowledge base API, for
+// end-to-end tests of the sync without a real Dify. It implements the dataset and
+// document endpoints DifyClient uses and keeps datasets and documents in memory, so
+// tests can assert on what was stored. Faults such as 429s, 5xx responses and slow
+// responses can be injected per endpoint.
+//
+// Create a client for it with dify.NewDifyClient(difytest.APIKey, srv.BaseURL()).
+package difytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey is the only API key the server accepts.
+const APIKey = "difytest-key"
+
+// Dataset is a stored dataset.
+type Dataset struct {
+	ID         string
+	Name       string
+	Permission string
+	CreatedAt  time.Time
+}
+
+// Document is a stored document.
+type Document struct {
+	ID                string
+	Name              string
+	Text              string
+	IndexingTechnique string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Updates           int // How many times it was updated since it was created
+}
+
+// Fault makes matching requests fail or respond slowly.
+type Fault struct {
+	Method string // Matches any method if empty
+	Path   string // Matches requests whose path contains it; any path if empty
+
+	Status     int           // Status to fail with, e.g. 429 or 503; 0 responds normally after Delay
+	RetryAfter string        // Retry-After header sent with the failure
+	Delay      time.Duration // How long to wait before responding
+	Times      int           // How many requests it affects; 0 affects all until ClearFaults
+}
+
+type dataset struct {
+	Dataset
+	docs  map[string]*Document
+	order []string // document IDs, oldest first
+}
+
+// Server is a fake Dify. Its zero value is not usable; create one with NewServer.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	datasets map[string]*dataset
+	order    []string // dataset IDs, oldest first
+	faults   []*Fault
+	requests []string
+	issued   int
+}
+
+// NewServer starts a fake Dify without datasets. Close it when done.
+func NewServer() *Server {
+	s := &Server{datasets: make(map[string]*dataset)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/datasets", s.handleListDatasets)
+	mux.HandleFunc("POST /v1/datasets", s.handleCreateDataset)
+	mux.HandleFunc("DELETE /v1/datasets/{dataset}", s.handleDeleteDataset)
+	mux.HandleFunc("GET /v1/datasets/{dataset}/documents", s.handleListDocuments)
+	mux.HandleFunc("POST /v1/datasets/{dataset}/document/create-by-text", s.handleCreateDocument)
+	mux.HandleFunc("POST /v1/datasets/{dataset}/document/create_by_text", s.handleCreateDocument)
+	mux.HandleFunc("POST /v1/datasets/{dataset}/documents/{document}/update_by_text", s.handleUpdateDocument)
+	mux.HandleFunc("POST /v1/datasets/{dataset}/documents/{document}/update-by-text", s.handleUpdateDocument)
+	mux.HandleFunc("DELETE /v1/datasets/{dataset}/documents/{document}", s.handleDeleteDocument)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+		f := s.matchFault(r)
+		s.mu.Unlock()
+
+		if f != nil {
+			if f.Delay > 0 {
+				select {
+				case <-time.After(f.Delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			if f.Status != 0 {
+				if f.RetryAfter != "" {
+					w.Header().Set("Retry-After", f.RetryAfter)
+				}
+				writeError(w, f.Status, "injected_fault", "injected fault")
+				return
+			}
+		}
+		if r.Header.Get("Authorization") != "Bearer "+APIKey {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Access token is invalid")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// BaseURL returns the API root to pass to dify.NewDifyClient, the counterpart of
+// https://api.dify.ai/v1.
+func (s *Server) BaseURL() string {
+	return s.URL + "/v1"
+}
+
+// Inject adds a fault. Faults are tried in the order they were added and the first
+// matching one with requests left applies.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// ClearFaults removes all faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// matchFault returns the fault to apply to r, counting it against its Times. The
+// caller must hold s.mu.
+func (s *Server) matchFault(r *http.Request) *Fault {
+	for i, f := range s.faults {
+		if (f.Method != "" && f.Method != r.Method) || !strings.Contains(r.URL.Path, f.Path) {
+			continue
+		}
+		applied := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return &applied
+	}
+	return nil
+}
+
+// Requests returns every request received so far as "METHOD /path?query", including
+// those failed by faults.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// AddDataset creates a dataset directly and returns its ID.
+func (s *Server) AddDataset(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createDatasetLocked(name, "only_me").ID
+}
+
+// Datasets returns all datasets, oldest first.
+func (s *Server) Datasets() []Dataset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Dataset, 0, len(s.order))
+	for _, id := range s.order {
+		list = append(list, s.datasets[id].Dataset)
+	}
+	return list
+}
+
+// Documents returns the documents of a dataset, oldest first, or nil if it does not exist.
+func (s *Server) Documents(datasetID string) []Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, ok := s.datasets[datasetID]
+	if !ok {
+		return nil
+	}
+	list := make([]Document, 0, len(ds.order))
+	for _, id := range ds.order {
+		list = append(list, *ds.docs[id])
+	}
+	return list
+}
+
+// Document returns a single document.
+func (s *Server) Document(datasetID, docID string) (Document, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, ok := s.datasets[datasetID]
+	if !ok {
+		return Document{}, false
+	}
+	doc, ok := ds.docs[docID]
+	if !ok {
+		return Document{}, false
+	}
+	return *doc, true
+}
+
+// DeleteDataset deletes a dataset behind the client's back, e.g. to test recovery.
+func (s *Server) DeleteDataset(datasetID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteDatasetLocked(datasetID)
+}
+
+// DeleteDocument deletes a document behind the client's back, e.g. to test recovery.
+func (s *Server) DeleteDocument(datasetID, docID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ds, ok := s.datasets[datasetID]; ok {
+		ds.deleteDocument(docID)
+	}
+}
+
+func (s *Server) nextID(prefix string) string {
+	s.issued++
+	return fmt.Sprintf("%s-%d", prefix, s.issued)
+}
+
+func (s *Server) createDatasetLocked(name, permission string) *dataset {
+	ds := &dataset{
+		Dataset: Dataset{ID: s.nextID("ds"), Name: name, Permission: permission, CreatedAt: time.Now().UTC()},
+		docs:    make(map[string]*Document),
+	}
+	s.datasets[ds.ID] = ds
+	s.order = append(s.order, ds.ID)
+	return ds
+}
+
+func (s *Server) deleteDatasetLocked(datasetID string) bool {
+	if _, ok := s.datasets[datasetID]; !ok {
+		return false
+	}
+	delete(s.datasets, datasetID)
+	s.order = remove(s.order, datasetID)
+	return true
+}
+
+func (ds *dataset) deleteDocument(docID string) bool {
+	if _, ok := ds.docs[docID]; !ok {
+		return false
+	}
+	delete(ds.docs, docID)
+	ds.order = remove(ds.order, docID)
+	return true
+}
+
+func remove(ids []string, id string) []string {
+	for i, v := range ids {
+		if v == id {
+			return append(ids[:i:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in Dify's shape.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{"code": code, "message": message, "status": status})
+}
+
+// paging reads page (from 1) and limit (default 20, at most 100) and returns the
+// range of n items they select.
+func paging(r *http.Request, n int) (page, limit, lo, hi int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	lo = min((page-1)*limit, n)
+	return page, limit, lo, min(lo+limit, n)
+}
+
+func datasetJSON(ds *dataset) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                 ds.ID,
+		"name":               ds.Name,
+		"description":        "",
+		"permission":         ds.Permission,
+		"data_source_type":   "upload_file",
+		"indexing_technique": "high_quality",
+		"document_count":     len(ds.docs),
+		"created_at":         ds.CreatedAt.Unix(),
+	}
+}
+
+func documentJSON(doc *Document) map[string]interface{} {
+	return map[string]interface{}{
+		"id":               doc.ID,
+		"name":             doc.Name,
+		"indexing_status":  "completed",
+		"enabled":          true,
+		"word_count":       len(strings.Fields(doc.Text)),
+		"created_at":       doc.CreatedAt.Unix(),
+		"data_source_type": "upload_file",
+	}
+}
+
+func (s *Server) handleListDatasets(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page, limit, lo, hi := paging(r, len(s.order))
+	data := make([]interface{}, 0, hi-lo)
+	for _, id := range s.order[lo:hi] {
+		data = append(data, datasetJSON(s.datasets[id]))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": data, "has_more": hi < len(s.order), "limit": limit, "total": len(s.order), "page": page,
+	})
+}
+
+func (s *Server) handleCreateDataset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name       string `json:"name"`
+		Permission string `json:"permission"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "invalid_param", "name is required")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ds := range s.datasets {
+		if ds.Name == req.Name {
+			writeError(w, http.StatusConflict, "dataset_name_duplicate", "The dataset name already exists.")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, datasetJSON(s.createDatasetLocked(req.Name, req.Permission)))
+}
+
+func (s *Server) handleDeleteDataset(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.deleteDatasetLocked(r.PathValue("dataset")) {
+		writeError(w, http.StatusNotFound, "not_found", "Dataset not found.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// datasetFor looks up the request's dataset, writing a 404 if it does not exist. The
+// caller must hold s.mu.
+func (s *Server) datasetFor(w http.ResponseWriter, r *http.Request) (*dataset, bool) {
+	ds, ok := s.datasets[r.PathValue("dataset")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "Dataset not found.")
+	}
+	return ds, ok
+}
+
+func (s *Server) handleListDocuments(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, ok := s.datasetFor(w, r)
+	if !ok {
+		return
+	}
+	// Dify lists the newest documents first.
+	keyword := r.URL.Query().Get("keyword")
+	var ids []string
+	for i := len(ds.order) - 1; i >= 0; i-- {
+		if id := ds.order[i]; keyword == "" || strings.Contains(ds.docs[id].Name, keyword) {
+			ids = append(ids, id)
+		}
+	}
+	page, limit, lo, hi := paging(r, len(ids))
+	data := make([]interface{}, 0, hi-lo)
+	for _, id := range ids[lo:hi] {
+		data = append(data, documentJSON(ds.docs[id]))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": data, "has_more": hi < len(ids), "limit": limit, "total": len(ids), "page": page,
+	})
+}
+
+type textRequest struct {
+	Name              string `json:"name"`
+	Text              string `json:"text"`
+	IndexingTechnique string `json:"indexing_technique"`
+}
+
+func (s *Server) handleCreateDocument(w http.ResponseWriter, r *http.Request) {
+	var req textRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.Text == "" {
+		writeError(w, http.StatusBadRequest, "invalid_param", "name and text are required")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, ok := s.datasetFor(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	doc := &Document{
+		ID:                s.nextID("doc"),
+		Name:              req.Name,
+		Text:              req.Text,
+		IndexingTechnique: req.IndexingTechnique,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	ds.docs[doc.ID] = doc
+	ds.order = append(ds.order, doc.ID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"document": documentJSON(doc), "batch": s.nextID("batch")})
+}
+
+func (s *Server) handleUpdateDocument(w http.ResponseWriter, r *http.Request) {
+	var req textRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_param", "invalid JSON body")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, ok := s.datasetFor(w, r)
+	if !ok {
+		return
+	}
+	doc, ok := ds.docs[r.PathValue("document")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "Document not found.")
+		return
+	}
+	if req.Name != "" {
+		doc.Name = req.Name
+	}
+	if req.Text != "" {
+		doc.Text = req.Text
+	}
+	doc.UpdatedAt = time.Now().UTC()
+	doc.Updates++
+	writeJSON(w, http.StatusOK, map[string]interface{}{"document": documentJSON(doc), "batch": s.nextID("batch")})
+}
+
+func (s *Server) handleDeleteDocument(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, ok := s.datasetFor(w, r)
+	if !ok {
+		return
+	}
+	if !ds.deleteDocument(r.PathValue("document")) {
+		writeError(w, http.StatusNotFound, "not_found", "Document not found.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,166 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// entry is a page URL discovered in a sitemap or feed.
+type entry struct {
+	URL     string
+	LastMod time.Time // zero when the sitemap or feed does not say
+}
+
+type urlSet struct {
+	URLs []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+}
+
+type sitemapIndex struct {
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+type rssFeed struct {
+	Channel struct {
+		Items []struct {
+			Link    string `xml:"link"`
+			PubDate string `xml:"pubDate"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomFeed struct {
+	Entries []struct {
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Updated string `xml:"updated"`
+	} `xml:"entry"`
+}
+
+// maxSitemapDepth bounds recursion through nested sitemap indexes.
+const maxSitemapDepth = 3
+
+// discover fetches feedURL and returns the page URLs it lists. isSitemap reports whether
+// the document was a sitemap, which (unlike a feed) lists every page of the site.
+func (s *Source) discover(ctx context.Context, feedURL string, depth int) (entries []entry, isSitemap bool, err error) {
+	body, err := s.fetchAll(ctx, feedURL)
+	if err != nil {
+		return nil, false, err
+	}
+
+	root, err := rootElement(body)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse %s: %w", feedURL, err)
+	}
+
+	switch root {
+	case "urlset":
+		var us urlSet
+		if err := xml.Unmarshal(body, &us); err != nil {
+			return nil, false, fmt.Errorf("failed to parse sitemap %s: %w", feedURL, err)
+		}
+		for _, u := range us.URLs {
+			entries = append(entries, entry{URL: strings.TrimSpace(u.Loc), LastMod: parseDate(u.LastMod)})
+		}
+		return entries, true, nil
+	case "sitemapindex":
+		if depth >= maxSitemapDepth {
+			return nil, false, fmt.Errorf("sitemap index %s nested too deeply", feedURL)
+		}
+		var si sitemapIndex
+		if err := xml.Unmarshal(body, &si); err != nil {
+			return nil, false, fmt.Errorf("failed to parse sitemap index %s: %w", feedURL, err)
+		}
+		for _, sm := range si.Sitemaps {
+			child, _, err := s.discover(ctx, strings.TrimSpace(sm.Loc), depth+1)
+			if err != nil {
+				return nil, false, err
+			}
+			entries = append(entries, child...)
+		}
+		return entries, true, nil
+	case "rss":
+		var rf rssFeed
+		if err := xml.Unmarshal(body, &rf); err != nil {
+			return nil, false, fmt.Errorf("failed to parse RSS feed %s: %w", feedURL, err)
+		}
+		for _, it := range rf.Channel.Items {
+			entries = append(entries, entry{URL: strings.TrimSpace(it.Link), LastMod: parseDate(it.PubDate)})
+		}
+		return entries, false, nil
+	case "feed":
+		var af atomFeed
+		if err := xml.Unmarshal(body, &af); err != nil {
+			return nil, false, fmt.Errorf("failed to parse Atom feed %s: %w", feedURL, err)
+		}
+		for _, e := range af.Entries {
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					entries = append(entries, entry{URL: strings.TrimSpace(l.Href), LastMod: parseDate(e.Updated)})
+					break
+				}
+			}
+		}
+		return entries, false, nil
+	default:
+		return nil, false, fmt.Errorf("%s is not a sitemap, RSS or Atom document (root element %q)", feedURL, root)
+	}
+}
+
+// fetchAll GETs url and returns its body.
+func (s *Source) fetchAll(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Dify-WP-Sync/1.0")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// rootElement returns the local name of the document's root element.
+func rootElement(body []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name.Local, nil
+		}
+	}
+}
+
+// parseDate understands the W3C datetime forms used by sitemaps and the RFC formats used by feeds.
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	layouts := []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02", time.RFC1123Z, time.RFC1123}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
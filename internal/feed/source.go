@@ -0,0 +1,260 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/source"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const batchSize = 20
+
+// boilerplate lists elements stripped from pages before their content is extracted.
+const boilerplate = "script, style, noscript, nav, header, footer, aside, form, iframe"
+
+// Source crawls the pages listed in a public sitemap or RSS/Atom feed. Pages are
+// identified by a hash of their URL, and ETags are kept on the site config so
+// unchanged pages are skipped with conditional requests.
+type Source struct {
+	siteCfg    *sites.SiteConfig
+	httpClient *http.Client
+	pending    map[string]string // ETags of fetched pages, recorded once they are synced
+}
+
+// NewSource returns a content source for a site registered with a feed URL.
+func NewSource(siteCfg *sites.SiteConfig) *Source {
+	if siteCfg.FeedETags == nil {
+		siteCfg.FeedETags = make(map[string]string)
+	}
+	return &Source{
+		siteCfg:    siteCfg,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		pending:    make(map[string]string),
+	}
+}
+
+// ItemSynced records the ETag of a synced page, so the next run can skip it while it
+// is unchanged.
+func (s *Source) ItemSynced(it source.Item) {
+	if etag, ok := s.pending[it.URL]; ok {
+		s.siteCfg.FeedETags[it.URL] = etag
+		delete(s.pending, it.URL)
+	}
+}
+
+// ItemID derives a stable post ID from a page URL.
+func ItemID(pageURL string) int {
+	h := fnv.New32a()
+	h.Write([]byte(pageURL))
+	return int(h.Sum32() & 0x7fffffff)
+}
+
+// entries discovers the site's pages, leaving out pages whose URL hashes to the same
+// item ID as another page; syncing both would merge them into one document. The page
+// that was synced before (it has an ETag) keeps the ID, otherwise the smaller URL does.
+func (s *Source) entries(ctx context.Context) ([]entry, bool, error) {
+	all, isSitemap, err := s.discover(ctx, s.siteCfg.FeedURL, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	owner := make(map[int]string, len(all))
+	for _, e := range all {
+		id := ItemID(e.URL)
+		cur, taken := owner[id]
+		if !taken || cur == e.URL {
+			owner[id] = e.URL
+			continue
+		}
+		_, curSynced := s.siteCfg.FeedETags[cur]
+		_, synced := s.siteCfg.FeedETags[e.URL]
+		if (synced && !curSynced) || (synced == curSynced && e.URL < cur) {
+			cur, owner[id] = e.URL, e.URL
+		}
+		logger.Log.Errorf("Pages %s and %s of site %s share item ID %d; only %s is synced",
+			cur, e.URL, s.siteCfg.SiteID, id, cur)
+	}
+
+	seen := make(map[string]bool, len(all))
+	entries := make([]entry, 0, len(owner))
+	for _, e := range all {
+		if owner[ItemID(e.URL)] != e.URL || seen[e.URL] {
+			continue
+		}
+		seen[e.URL] = true
+		entries = append(entries, e)
+	}
+	return entries, isSitemap, nil
+}
+
+// unchanged reports whether e can be skipped because its lastmod is not after since.
+// A date-only lastmod (midnight) covers its whole day, so such a page keeps being
+// checked, with a conditional request, until since is past the end of that day.
+func unchanged(e entry, since time.Time) bool {
+	if e.LastMod.IsZero() {
+		return false
+	}
+	lastMod := e.LastMod
+	if lastMod.Equal(lastMod.Truncate(24 * time.Hour)) {
+		lastMod = lastMod.Add(24*time.Hour - time.Nanosecond)
+	}
+	return !lastMod.After(since)
+}
+
+// ListChanged fetches every listed page whose lastmod is after since (or that has never
+// been synced) and whose ETag changed, passing the extracted pages to fn in batches.
+func (s *Source) ListChanged(ctx context.Context, since time.Time, fn func(items []source.Item) error) error {
+	entries, _, err := s.entries(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infof("Discovered %d pages from %s", len(entries), s.siteCfg.FeedURL)
+
+	var batch []source.Item
+	for _, e := range entries {
+		_, synced := s.siteCfg.PostDocMapping[ItemID(e.URL)]
+		if synced && unchanged(e, since) {
+			continue
+		}
+
+		it, err := s.fetchPage(ctx, e, synced)
+		if err != nil {
+			logger.Log.Errorf("Failed to fetch %s: %v", e.URL, err)
+			continue
+		}
+		if it == nil {
+			continue // not modified
+		}
+		batch = append(batch, *it)
+		if len(batch) == batchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// GetItem fetches the listed page whose URL hashes to id.
+func (s *Source) GetItem(ctx context.Context, id int) (*source.Item, error) {
+	entries, _, err := s.entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if ItemID(e.URL) == id {
+			return s.fetchPage(ctx, e, false)
+		}
+	}
+	return nil, source.ErrNotFound
+}
+
+// ListIDs returns the IDs of all pages in a sitemap. Feeds only list recent entries,
+// so for them a missing page does not mean it was removed.
+func (s *Source) ListIDs(ctx context.Context) ([]int, error) {
+	entries, isSitemap, err := s.entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !isSitemap {
+		return nil, source.ErrListUnsupported
+	}
+	ids := make([]int, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, ItemID(e.URL))
+	}
+	return ids, nil
+}
+
+// fetchPage downloads a page and extracts its main content. When conditional is set
+// the stored ETag is sent, and a nil item is returned if the page is unchanged.
+func (s *Source) fetchPage(ctx context.Context, e entry, conditional bool) (*source.Item, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", e.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Dify-WP-Sync/1.0")
+	if etag := s.siteCfg.FeedETags[e.URL]; conditional && etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, source.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		s.pending[e.URL] = etag
+	}
+
+	// Left zero when neither the listing nor the server says, so a made-up time
+	// cannot move the site's last sync time past pages that were not synced yet.
+	modified := e.LastMod
+	if modified.IsZero() {
+		modified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	}
+
+	content, err := mainContent(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &source.Item{
+		ID:       ItemID(e.URL),
+		Title:    pageTitle(doc, e.URL),
+		Content:  content,
+		Type:     "page",
+		URL:      e.URL,
+		Modified: modified,
+	}, nil
+}
+
+// mainContent returns the HTML of the page's article, falling back to <main> and then <body>.
+func mainContent(doc *goquery.Document) (string, error) {
+	sel := doc.Find("article").First()
+	if sel.Length() == 0 {
+		sel = doc.Find("main").First()
+	}
+	if sel.Length() == 0 {
+		sel = doc.Find("body")
+	}
+	sel.Find(boilerplate).Remove()
+	return sel.Html()
+}
+
+// pageTitle prefers the Open Graph title, then <title>, then the URL.
+func pageTitle(doc *goquery.Document, pageURL string) string {
+	if t, ok := doc.Find(`meta[property="og:title"]`).Attr("content"); ok && strings.TrimSpace(t) != "" {
+		return strings.TrimSpace(t)
+	}
+	if t := strings.TrimSpace(doc.Find("title").First().Text()); t != "" {
+		return t
+	}
+	return pageURL
+}
@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/storage"
+)
+
+// Job kinds.
+const (
+	KindSync      = "sync"
+	KindForceSync = "force-sync"
+	KindPost      = "post" // Applies a webhook notification to one post
+)
+
+// Job statuses.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// jobTTL is how long finished job records are kept for status queries.
+const jobTTL = 7 * 24 * time.Hour
+
+// ErrNotFound is returned when a job ID is unknown or has expired.
+var ErrNotFound = errors.New("job not found")
+
+// Job is a requested sync of one site and its current state.
+type Job struct {
+	ID         string    `json:"id"`
+	SiteID     string    `json:"site_id"`
+	Kind       string    `json:"kind"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Attempts   int       `json:"attempts,omitempty"`
+	PostID     int       `json:"post_id,omitempty"`  // Post jobs only
+	Modified   time.Time `json:"modified,omitempty"` // Post jobs only: the notified modification time
+	Trash      bool      `json:"trash,omitempty"`    // Post jobs only: the post was trashed
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// Queue accepts sync jobs and reports their status.
+type Queue interface {
+	Enqueue(ctx context.Context, siteID, kind string) (*Job, error)
+	EnqueuePost(ctx context.Context, siteID string, postID int, modified time.Time, trash bool) (*Job, error)
+	Get(ctx context.Context, jobID string) (*Job, error)
+}
+
+// Store persists job records in the shared store so any process can report their status.
+type Store struct {
+	store storage.Store
+}
+
+func NewStore(store storage.Store) *Store {
+	return &Store{store: store}
+}
+
+func (s *Store) jobKey(jobID string) string {
+	return fmt.Sprintf("wp_job:%s", jobID)
+}
+
+// New creates and saves a queued sync job.
+func (s *Store) New(ctx context.Context, siteID, kind string) (*Job, error) {
+	if kind != KindSync && kind != KindForceSync {
+		return nil, fmt.Errorf("unknown job kind %q", kind)
+	}
+	return s.create(ctx, &Job{SiteID: siteID, Kind: kind})
+}
+
+// NewPost creates and saves a queued job applying a notified change to one post.
+func (s *Store) NewPost(ctx context.Context, siteID string, postID int, modified time.Time, trash bool) (*Job, error) {
+	return s.create(ctx, &Job{SiteID: siteID, Kind: KindPost, PostID: postID, Modified: modified, Trash: trash})
+}
+
+func (s *Store) create(ctx context.Context, job *Job) (*Job, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	job.ID = hex.EncodeToString(b)
+	job.Status = StatusQueued
+	job.CreatedAt = time.Now().UTC()
+	return job, s.Save(ctx, job)
+}
+
+func (s *Store) Save(ctx context.Context, job *Job) error {
+	return s.store.SetJSON(ctx, s.jobKey(job.ID), job, jobTTL)
+}
+
+func (s *Store) Get(ctx context.Context, jobID string) (*Job, error) {
+	var job Job
+	found, err := s.store.GetJSON(ctx, s.jobKey(jobID), &job)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sink"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/syncer"
+)
+
+// jobLockWait is how long a job waits for a sync already running on its site. Post
+// jobs wait longer, as they are small and a notification should not be lost to a
+// full sync that happens to be running.
+const (
+	jobLockWait  = time.Minute
+	postLockWait = 10 * time.Minute
+)
+
+// Execute runs a job to completion, saving its state transitions. The job's kind
+// doubles as the trigger recorded in the site's sync history.
+func Execute(ctx context.Context, store *Store, sitesMgr *sites.Manager, difyClient *dify.DifyClient, job *Job, sinks ...sink.Sink) error {
+	job.Status = StatusRunning
+	job.StartedAt = time.Now().UTC()
+	if err := store.Save(ctx, job); err != nil {
+		logger.Log.Warnf("Failed to save job %s: %v", job.ID, err)
+	}
+
+	err := run(ctx, sitesMgr, difyClient, job, sinks...)
+
+	job.FinishedAt = time.Now().UTC()
+	job.Status = StatusSucceeded
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		logger.Log.Errorf("Job %s (%s of site %s) failed: %v", job.ID, job.Kind, job.SiteID, err)
+	} else {
+		logger.Log.Infof("Job %s (%s of site %s) finished", job.ID, job.Kind, job.SiteID)
+	}
+	if saveErr := store.Save(ctx, job); saveErr != nil {
+		logger.Log.Warnf("Failed to save job %s: %v", job.ID, saveErr)
+	}
+	return err
+}
+
+func run(ctx context.Context, sitesMgr *sites.Manager, difyClient *dify.DifyClient, job *Job, sinks ...sink.Sink) error {
+	if job.Kind == KindPost {
+		return syncer.SyncPost(ctx, sitesMgr, difyClient, job.SiteID, syncer.PostChange{
+			PostID:   job.PostID,
+			Modified: job.Modified,
+			Trash:    job.Trash,
+		}, postLockWait, sinks...)
+	}
+	return syncer.SyncRegisteredSite(ctx, sitesMgr, difyClient, job.SiteID, syncer.RunOptions{
+		Trigger:  "job:" + job.Kind,
+		LockWait: jobLockWait,
+		Reset:    job.Kind == KindForceSync,
+		Sinks:    sinks,
+	})
+}
+
+// LocalQueue runs jobs in goroutines of the current process.
+type LocalQueue struct {
+	store      *Store
+	sitesMgr   *sites.Manager
+	difyClient *dify.DifyClient
+	sinks      []sink.Sink
+}
+
+func NewLocalQueue(store *Store, sitesMgr *sites.Manager, difyClient *dify.DifyClient, sinks ...sink.Sink) *LocalQueue {
+	return &LocalQueue{store: store, sitesMgr: sitesMgr, difyClient: difyClient, sinks: sinks}
+}
+
+func (q *LocalQueue) Enqueue(ctx context.Context, siteID, kind string) (*Job, error) {
+	job, err := q.store.New(ctx, siteID, kind)
+	if err != nil {
+		return nil, err
+	}
+	go Execute(context.Background(), q.store, q.sitesMgr, q.difyClient, job, q.sinks...)
+	return job, nil
+}
+
+func (q *LocalQueue) EnqueuePost(ctx context.Context, siteID string, postID int, modified time.Time, trash bool) (*Job, error) {
+	job, err := q.store.NewPost(ctx, siteID, postID, modified, trash)
+	if err != nil {
+		return nil, err
+	}
+	go Execute(context.Background(), q.store, q.sitesMgr, q.difyClient, job, q.sinks...)
+	return job, nil
+}
+
+func (q *LocalQueue) Get(ctx context.Context, jobID string) (*Job, error) {
+	return q.store.Get(ctx, jobID)
+}
@@ -0,0 +1,173 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/redisstore"
+	"dify-wp-sync/internal/sink"
+	"dify-wp-sync/internal/sites"
+)
+
+const (
+	jobsStream  = "wp_sync_jobs"
+	jobsGroup   = "wp_sync_workers"
+	maxAttempts = 3
+	readBlock   = 5 * time.Second
+)
+
+// StreamQueue enqueues jobs on a Redis stream for Worker processes to claim.
+type StreamQueue struct {
+	store *Store
+	redis *redisstore.RedisStore
+}
+
+func NewStreamQueue(store *Store, redis *redisstore.RedisStore) *StreamQueue {
+	return &StreamQueue{store: store, redis: redis}
+}
+
+func (q *StreamQueue) Enqueue(ctx context.Context, siteID, kind string) (*Job, error) {
+	job, err := q.store.New(ctx, siteID, kind)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := q.redis.XAdd(ctx, jobsStream, job.ID); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (q *StreamQueue) EnqueuePost(ctx context.Context, siteID string, postID int, modified time.Time, trash bool) (*Job, error) {
+	job, err := q.store.NewPost(ctx, siteID, postID, modified, trash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := q.redis.XAdd(ctx, jobsStream, job.ID); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (q *StreamQueue) Get(ctx context.Context, jobID string) (*Job, error) {
+	return q.store.Get(ctx, jobID)
+}
+
+// Worker claims jobs from the stream through a consumer group. Entries are acknowledged
+// and deleted once their job finishes, as the job record outlives them; entries left pending longer than the visibility timeout (for
+// example because a worker crashed) are reclaimed by another worker. While a job runs
+// its entry is periodically re-claimed so a slow sync is not mistaken for a dead one.
+type Worker struct {
+	Name              string
+	VisibilityTimeout time.Duration
+
+	store      *Store
+	redis      *redisstore.RedisStore
+	sitesMgr   *sites.Manager
+	difyClient *dify.DifyClient
+	sinks      []sink.Sink
+}
+
+func NewWorker(name string, visibilityTimeout time.Duration, store *Store, redis *redisstore.RedisStore, sitesMgr *sites.Manager, difyClient *dify.DifyClient, sinks ...sink.Sink) *Worker {
+	return &Worker{
+		Name:              name,
+		VisibilityTimeout: visibilityTimeout,
+		store:             store,
+		redis:             redis,
+		sitesMgr:          sitesMgr,
+		difyClient:        difyClient,
+		sinks:             sinks,
+	}
+}
+
+// Run processes jobs until ctx is cancelled. A job in progress is finished first.
+func (w *Worker) Run(ctx context.Context) error {
+	if err := w.redis.EnsureGroup(ctx, jobsStream, jobsGroup); err != nil {
+		return err
+	}
+	logger.Log.Infof("Worker %s started", w.Name)
+
+	for ctx.Err() == nil {
+		msgs, err := w.redis.XAutoClaim(ctx, jobsStream, jobsGroup, w.Name, w.VisibilityTimeout, 1)
+		if err == nil && len(msgs) == 0 {
+			msgs, err = w.redis.XReadGroup(ctx, jobsStream, jobsGroup, w.Name, 1, readBlock)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logger.Log.Errorf("Worker %s failed to read jobs: %v", w.Name, err)
+			time.Sleep(readBlock)
+			continue
+		}
+		for _, msg := range msgs {
+			w.handle(msg)
+		}
+	}
+	logger.Log.Infof("Worker %s stopped", w.Name)
+	return nil
+}
+
+// handle runs one stream entry's job and acknowledges it. It deliberately ignores the
+// worker's context so shutdown waits for the current job instead of abandoning it.
+// An entry whose job cannot be loaded because of a store error is left unacknowledged,
+// so it is reclaimed once the visibility timeout passes, until it has been delivered
+// more than maxAttempts times, for example because the record cannot be decoded;
+// unknown or expired jobs are dropped right away.
+func (w *Worker) handle(msg redisstore.StreamMessage) {
+	ctx := context.Background()
+	job, err := w.store.Get(ctx, msg.Data)
+	if err != nil && !errors.Is(err, ErrNotFound) && msg.Deliveries <= maxAttempts {
+		logger.Log.Errorf("Worker %s failed to load job %s, leaving it for redelivery: %v", w.Name, msg.Data, err)
+		return
+	}
+	defer func() {
+		if err := w.redis.XAckDel(ctx, jobsStream, jobsGroup, msg.ID); err != nil {
+			logger.Log.Errorf("Worker %s failed to ack job entry %s: %v", w.Name, msg.ID, err)
+		}
+	}()
+	if err != nil {
+		logger.Log.Warnf("Worker %s dropping job %s after %d deliveries: %v", w.Name, msg.Data, msg.Deliveries, err)
+		return
+	}
+	if job.Status == StatusSucceeded || job.Status == StatusFailed {
+		return
+	}
+
+	job.Attempts++
+	if job.Attempts > maxAttempts {
+		job.Status = StatusFailed
+		job.Error = "abandoned after repeated worker failures"
+		job.FinishedAt = time.Now().UTC()
+		if err := w.store.Save(ctx, job); err != nil {
+			logger.Log.Warnf("Failed to save job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	stop := w.heartbeat(msg.ID)
+	defer stop()
+	Execute(ctx, w.store, w.sitesMgr, w.difyClient, job, w.sinks...)
+}
+
+// heartbeat keeps the entry claimed by this worker until the returned func is called.
+func (w *Worker) heartbeat(entryID string) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(w.VisibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := w.redis.XClaim(context.Background(), jobsStream, jobsGroup, w.Name, entryID); err != nil {
+					logger.Log.Warnf("Worker %s failed to extend claim on %s: %v", w.Name, entryID, err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
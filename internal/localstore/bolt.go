@@ -0,0 +1,81 @@
+package localstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("kv")
+
+// boltBackend stores JSON-encoded entries in a single bbolt bucket. bbolt locks the
+// file, so only one process can have it open at a time.
+type boltBackend struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens (creating if needed) the bbolt database file at path.
+func OpenBolt(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store file %s (is another process using it?): %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{b: &boltBackend{db: db}}, nil
+}
+
+func (b *boltBackend) view(fn func(txn) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTxn{tx.Bucket(boltBucket)})
+	})
+}
+
+func (b *boltBackend) update(fn func(txn) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTxn{tx.Bucket(boltBucket)})
+	})
+}
+
+func (b *boltBackend) close() error {
+	return b.db.Close()
+}
+
+type boltTxn struct {
+	bucket *bolt.Bucket
+}
+
+func (t boltTxn) get(key string) (*entry, error) {
+	raw := t.bucket.Get([]byte(key))
+	if raw == nil {
+		return nil, nil
+	}
+	var e entry
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, fmt.Errorf("corrupt entry for key %s: %w", key, err)
+	}
+	if e.expired(time.Now()) {
+		return nil, nil
+	}
+	return &e, nil
+}
+
+func (t boltTxn) put(key string, e *entry) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return t.bucket.Put([]byte(key), raw)
+}
+
+func (t boltTxn) del(key string) error {
+	return t.bucket.Delete([]byte(key))
+}
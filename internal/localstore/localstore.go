@@ -0,0 +1,466 @@
+// Package localstore implements storage.Store without Redis, either purely in
+// memory or in an embedded bbolt file. Both keep every key as an entry holding a
+// plain value, a hash, a set or a list, mirroring the Redis commands the rest of
+// the application relies on.
+package localstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"dify-wp-sync/internal/storage"
+)
+
+// entry is the value stored under one key.
+type entry struct {
+	Value     string            `json:"v,omitempty"`
+	Hash      map[string]string `json:"h,omitempty"`
+	Set       map[string]bool   `json:"s,omitempty"`
+	List      []string          `json:"l,omitempty"`
+	ExpiresAt int64             `json:"x,omitempty"` // Unix milliseconds; 0 never expires
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.ExpiresAt != 0 && now.UnixMilli() >= e.ExpiresAt
+}
+
+func expiresAt(expiration time.Duration) int64 {
+	if expiration <= 0 {
+		return 0
+	}
+	return time.Now().Add(expiration).UnixMilli()
+}
+
+// txn reads and writes entries within one backend transaction. get returns nil for
+// missing and expired keys.
+type txn interface {
+	get(key string) (*entry, error)
+	put(key string, e *entry) error
+	del(key string) error
+}
+
+type backend interface {
+	view(fn func(txn) error) error
+	update(fn func(txn) error) error
+	close() error
+}
+
+// Store is a storage.Store backed by memory or a bbolt file.
+type Store struct {
+	b backend
+}
+
+var _ storage.Store = (*Store)(nil)
+
+func (s *Store) Close() error {
+	return s.b.close()
+}
+
+func (s *Store) SetJSON(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.b.update(func(tx txn) error {
+		return tx.put(key, &entry{Value: string(b), ExpiresAt: expiresAt(expiration)})
+	})
+}
+
+func (s *Store) GetJSON(ctx context.Context, key string, dest interface{}) (bool, error) {
+	var value string
+	var found bool
+	err := s.b.view(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil || e == nil {
+			return err
+		}
+		value, found = e.Value, true
+		return nil
+	})
+	if err != nil || !found {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(value), dest)
+}
+
+// SetNX sets key only if it does not exist yet, reporting whether it was set.
+func (s *Store) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	var set bool
+	err = s.b.update(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil || e != nil {
+			return err
+		}
+		set = true
+		return tx.put(key, &entry{Value: string(b), ExpiresAt: expiresAt(expiration)})
+	})
+	return set, err
+}
+
+func (s *Store) Del(ctx context.Context, keys ...string) error {
+	return s.b.update(func(tx txn) error {
+		for _, key := range keys {
+			if err := tx.del(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) HSetJSON(ctx context.Context, key, field string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.b.update(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			e = &entry{}
+		}
+		if e.Hash == nil {
+			e.Hash = make(map[string]string)
+		}
+		e.Hash[field] = string(b)
+		return tx.put(key, e)
+	})
+}
+
+func (s *Store) HGetJSON(ctx context.Context, key, field string, dest interface{}) (bool, error) {
+	var value string
+	var found bool
+	err := s.b.view(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil || e == nil {
+			return err
+		}
+		value, found = e.Hash[field]
+		return nil
+	})
+	if err != nil || !found {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(value), dest)
+}
+
+func (s *Store) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	all := make(map[string]string)
+	err := s.b.view(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil || e == nil {
+			return err
+		}
+		for field, value := range e.Hash {
+			all[field] = value
+		}
+		return nil
+	})
+	return all, err
+}
+
+func (s *Store) HDel(ctx context.Context, key string, fields ...string) error {
+	return s.b.update(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil || e == nil {
+			return err
+		}
+		for _, field := range fields {
+			delete(e.Hash, field)
+		}
+		if len(e.Hash) == 0 {
+			return tx.del(key)
+		}
+		return tx.put(key, e)
+	})
+}
+
+func (s *Store) HReplaceJSON(ctx context.Context, key string, fields map[string]interface{}) error {
+	hash := make(map[string]string, len(fields))
+	for field, value := range fields {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		hash[field] = string(b)
+	}
+	return s.b.update(func(tx txn) error {
+		if len(hash) == 0 {
+			return tx.del(key)
+		}
+		return tx.put(key, &entry{Hash: hash})
+	})
+}
+
+func (s *Store) SAdd(ctx context.Context, key string, members ...string) error {
+	return s.b.update(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			e = &entry{}
+		}
+		if e.Set == nil {
+			e.Set = make(map[string]bool)
+		}
+		for _, member := range members {
+			e.Set[member] = true
+		}
+		return tx.put(key, e)
+	})
+}
+
+func (s *Store) SMembers(ctx context.Context, key string) ([]string, error) {
+	var members []string
+	err := s.b.view(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil || e == nil {
+			return err
+		}
+		for member := range e.Set {
+			members = append(members, member)
+		}
+		return nil
+	})
+	sort.Strings(members)
+	return members, err
+}
+
+func (s *Store) SRem(ctx context.Context, key string, members ...string) error {
+	return s.b.update(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil || e == nil {
+			return err
+		}
+		for _, member := range members {
+			delete(e.Set, member)
+		}
+		if len(e.Set) == 0 {
+			return tx.del(key)
+		}
+		return tx.put(key, e)
+	})
+}
+
+func (s *Store) LPushJSON(ctx context.Context, key string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.b.update(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			e = &entry{}
+		}
+		e.List = append([]string{string(b)}, e.List...)
+		return tx.put(key, e)
+	})
+}
+
+// listRange resolves Redis-style inclusive, possibly negative, indexes against a
+// list of length n. It returns an empty range as lo >= hi.
+func listRange(n int, start, stop int64) (lo, hi int) {
+	if start < 0 {
+		start += int64(n)
+	}
+	if stop < 0 {
+		stop += int64(n)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(n) {
+		stop = int64(n) - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
+
+func (s *Store) LTrim(ctx context.Context, key string, start, stop int64) error {
+	return s.b.update(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil || e == nil {
+			return err
+		}
+		lo, hi := listRange(len(e.List), start, stop)
+		if lo >= hi {
+			return tx.del(key)
+		}
+		e.List = append([]string(nil), e.List[lo:hi]...)
+		return tx.put(key, e)
+	})
+}
+
+func (s *Store) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	var values []string
+	err := s.b.view(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil || e == nil {
+			return err
+		}
+		lo, hi := listRange(len(e.List), start, stop)
+		if lo < hi {
+			values = append(values, e.List[lo:hi]...)
+		}
+		return nil
+	})
+	return values, err
+}
+
+// lease is a storage.Lease held in a local store.
+type lease struct {
+	token int64
+	store *Store
+	key   string
+	value string
+	ttl   time.Duration
+}
+
+func fenceToken(tx txn, fenceKey string) (int64, error) {
+	e, err := tx.get(fenceKey)
+	if err != nil || e == nil {
+		return 0, err
+	}
+	return strconv.ParseInt(e.Value, 10, 64)
+}
+
+// AcquireLease tries once to take the lock at key for ttl, returning storage.ErrLocked if it is held.
+// fenceKey holds the counter the fencing token is drawn from.
+func (s *Store) AcquireLease(ctx context.Context, key, fenceKey, owner string, ttl time.Duration) (storage.Lease, error) {
+	var l *lease
+	err := s.b.update(func(tx txn) error {
+		held, err := tx.get(key)
+		if err != nil {
+			return err
+		}
+		if held != nil {
+			return storage.ErrLocked
+		}
+		token, err := fenceToken(tx, fenceKey)
+		if err != nil {
+			return err
+		}
+		token++
+		if err := tx.put(fenceKey, &entry{Value: strconv.FormatInt(token, 10)}); err != nil {
+			return err
+		}
+		l = &lease{token: token, store: s, key: key, value: fmt.Sprintf("%d:%s", token, owner), ttl: ttl}
+		return tx.put(key, &entry{Value: l.value, ExpiresAt: expiresAt(ttl)})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// LeaseHolder returns the owner description stored in the lock at key, or "" if it is free.
+func (s *Store) LeaseHolder(ctx context.Context, key string) (string, error) {
+	var holder string
+	err := s.b.view(func(tx txn) error {
+		e, err := tx.get(key)
+		if err != nil || e == nil {
+			return err
+		}
+		holder = e.Value
+		return nil
+	})
+	return holder, err
+}
+
+func (l *lease) Token() int64 {
+	return l.token
+}
+
+// Renew extends the lease by its full TTL.
+func (l *lease) Renew(ctx context.Context) error {
+	return l.store.b.update(func(tx txn) error {
+		e, err := tx.get(l.key)
+		if err != nil {
+			return err
+		}
+		if e == nil || e.Value != l.value {
+			return storage.ErrLeaseLost
+		}
+		e.ExpiresAt = expiresAt(l.ttl)
+		return tx.put(l.key, e)
+	})
+}
+
+// Release frees the lease if it is still held.
+func (l *lease) Release(ctx context.Context) error {
+	return l.store.b.update(func(tx txn) error {
+		e, err := tx.get(l.key)
+		if err != nil {
+			return err
+		}
+		if e == nil || e.Value != l.value {
+			return storage.ErrLeaseLost
+		}
+		return tx.del(l.key)
+	})
+}
+
+// SetJSONFenced writes value to key only while token is the latest token issued on fenceKey
+// and the value stored at key has no schema_version above maxVersion.
+func (s *Store) SetJSONFenced(ctx context.Context, fenceKey string, token int64, key string, maxVersion int, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.b.update(func(tx txn) error {
+		current, err := fenceToken(tx, fenceKey)
+		if err != nil {
+			return err
+		}
+		if current != token {
+			return storage.ErrFenced
+		}
+		return putVersioned(tx, key, maxVersion, b)
+	})
+}
+
+// SetJSONVersioned writes value to key unless the JSON object stored there has a
+// schema_version above maxVersion.
+func (s *Store) SetJSONVersioned(ctx context.Context, key string, maxVersion int, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.b.update(func(tx txn) error {
+		return putVersioned(tx, key, maxVersion, b)
+	})
+}
+
+// putVersioned writes b to key within tx unless the stored value is a JSON object with
+// a schema_version above maxVersion.
+func putVersioned(tx txn, key string, maxVersion int, b []byte) error {
+	e, err := tx.get(key)
+	if err != nil {
+		return err
+	}
+	if e != nil {
+		var stored struct {
+			SchemaVersion int `json:"schema_version"`
+		}
+		if json.Unmarshal([]byte(e.Value), &stored) == nil && stored.SchemaVersion > maxVersion {
+			return storage.ErrNewerVersion
+		}
+	}
+	return tx.put(key, &entry{Value: string(b)})
+}
@@ -0,0 +1,216 @@
+package localstore
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"dify-wp-sync/internal/storage"
+)
+
+// forEachBackend runs fn against an in-memory store and a bbolt file.
+func forEachBackend(t *testing.T, fn func(t *testing.T, s *Store)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemory())
+	})
+	t.Run("bolt", func(t *testing.T) {
+		s, err := OpenBolt(filepath.Join(t.TempDir(), "state.db"))
+		if err != nil {
+			t.Fatalf("OpenBolt: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		fn(t, s)
+	})
+}
+
+func TestValues(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		var got map[string]int
+		if found, err := s.GetJSON(ctx, "missing", &got); found || err != nil {
+			t.Fatalf("GetJSON of a missing key = %v, %v; want false, nil", found, err)
+		}
+		if err := s.SetJSON(ctx, "k", map[string]int{"a": 1}, 0); err != nil {
+			t.Fatalf("SetJSON: %v", err)
+		}
+		if found, err := s.GetJSON(ctx, "k", &got); !found || err != nil || got["a"] != 1 {
+			t.Fatalf("GetJSON = %v, %v, %v; want the stored value", got, found, err)
+		}
+
+		if first, err := s.SetNX(ctx, "once", "a", 0); !first || err != nil {
+			t.Fatalf("first SetNX = %v, %v; want true", first, err)
+		}
+		if first, err := s.SetNX(ctx, "once", "b", 0); first || err != nil {
+			t.Fatalf("second SetNX = %v, %v; want false", first, err)
+		}
+		var once string
+		if _, err := s.GetJSON(ctx, "once", &once); err != nil || once != "a" {
+			t.Errorf("SetNX value read back as %q, %v; want the first value as JSON", once, err)
+		}
+
+		if err := s.Del(ctx, "k", "once", "missing"); err != nil {
+			t.Fatalf("Del: %v", err)
+		}
+		if found, _ := s.GetJSON(ctx, "k", &got); found {
+			t.Error("deleted key is still found")
+		}
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		if err := s.SetJSON(ctx, "short", 1, 20*time.Millisecond); err != nil {
+			t.Fatalf("SetJSON: %v", err)
+		}
+		if first, err := s.SetNX(ctx, "short", 2, 0); first || err != nil {
+			t.Fatalf("SetNX on a live key = %v, %v; want false", first, err)
+		}
+		time.Sleep(30 * time.Millisecond)
+		var v int
+		if found, err := s.GetJSON(ctx, "short", &v); found || err != nil {
+			t.Fatalf("GetJSON of an expired key = %v, %v; want false, nil", found, err)
+		}
+		if first, err := s.SetNX(ctx, "short", 2, 0); !first || err != nil {
+			t.Errorf("SetNX on an expired key = %v, %v; want true", first, err)
+		}
+	})
+}
+
+func TestHashesSetsAndLists(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		for field, v := range map[string]string{"1": "doc-1", "2": "doc-2", "3": "doc-3"} {
+			if err := s.HSetJSON(ctx, "h", field, v); err != nil {
+				t.Fatalf("HSetJSON: %v", err)
+			}
+		}
+		if err := s.HDel(ctx, "h", "2"); err != nil {
+			t.Fatalf("HDel: %v", err)
+		}
+		var doc string
+		if found, err := s.HGetJSON(ctx, "h", "3", &doc); !found || err != nil || doc != "doc-3" {
+			t.Errorf("HGetJSON = %q, %v, %v; want doc-3", doc, found, err)
+		}
+		all, err := s.HGetAll(ctx, "h")
+		if want := map[string]string{"1": `"doc-1"`, "3": `"doc-3"`}; err != nil || !maps.Equal(all, want) {
+			t.Errorf("HGetAll = %v, %v; want %v", all, err, want)
+		}
+		if err := s.HReplaceJSON(ctx, "h", map[string]interface{}{"4": "doc-4"}); err != nil {
+			t.Fatalf("HReplaceJSON: %v", err)
+		}
+		all, err = s.HGetAll(ctx, "h")
+		if want := map[string]string{"4": `"doc-4"`}; err != nil || !maps.Equal(all, want) {
+			t.Errorf("HGetAll after HReplaceJSON = %v, %v; want %v", all, err, want)
+		}
+
+		if err := s.SAdd(ctx, "set", "b", "a", "b"); err != nil {
+			t.Fatalf("SAdd: %v", err)
+		}
+		if err := s.SRem(ctx, "set", "b"); err != nil {
+			t.Fatalf("SRem: %v", err)
+		}
+		if members, err := s.SMembers(ctx, "set"); err != nil || !slices.Equal(members, []string{"a"}) {
+			t.Errorf("SMembers = %v, %v; want [a]", members, err)
+		}
+
+		for i := 1; i <= 4; i++ {
+			if err := s.LPushJSON(ctx, "list", i); err != nil {
+				t.Fatalf("LPushJSON: %v", err)
+			}
+		}
+		if err := s.LTrim(ctx, "list", 0, 2); err != nil {
+			t.Fatalf("LTrim: %v", err)
+		}
+		if values, err := s.LRange(ctx, "list", 0, -1); err != nil || !slices.Equal(values, []string{"4", "3", "2"}) {
+			t.Errorf("LRange = %v, %v; want the three newest, newest first", values, err)
+		}
+	})
+}
+
+func TestLeasesAndFencing(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		first, err := s.AcquireLease(ctx, "lock", "fence", "worker-1", time.Minute)
+		if err != nil {
+			t.Fatalf("AcquireLease: %v", err)
+		}
+		if _, err := s.AcquireLease(ctx, "lock", "fence", "worker-2", time.Minute); !errors.Is(err, storage.ErrLocked) {
+			t.Fatalf("AcquireLease of a held lock = %v, want ErrLocked", err)
+		}
+		if holder, err := s.LeaseHolder(ctx, "lock"); err != nil || !strings.Contains(holder, "worker-1") {
+			t.Errorf("LeaseHolder = %q, %v; want it to name worker-1", holder, err)
+		}
+		if err := s.SetJSONFenced(ctx, "fence", first.Token(), "cfg", 1, map[string]int{"schema_version": 1}); err != nil {
+			t.Fatalf("SetJSONFenced by the holder: %v", err)
+		}
+
+		if err := first.Release(ctx); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+		if err := first.Renew(ctx); !errors.Is(err, storage.ErrLeaseLost) {
+			t.Errorf("Renew of a released lease = %v, want ErrLeaseLost", err)
+		}
+		second, err := s.AcquireLease(ctx, "lock", "fence", "worker-2", time.Minute)
+		if err != nil {
+			t.Fatalf("AcquireLease after Release: %v", err)
+		}
+		if second.Token() <= first.Token() {
+			t.Errorf("tokens %d then %d, want them increasing", first.Token(), second.Token())
+		}
+		if err := s.SetJSONFenced(ctx, "fence", first.Token(), "cfg", 1, map[string]int{"schema_version": 1}); !errors.Is(err, storage.ErrFenced) {
+			t.Errorf("SetJSONFenced with the old token = %v, want ErrFenced", err)
+		}
+	})
+}
+
+func TestVersionedWritesRefuseNewerRecords(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		if err := s.SetJSON(ctx, "cfg", map[string]int{"schema_version": 3}, 0); err != nil {
+			t.Fatalf("SetJSON: %v", err)
+		}
+		if err := s.SetJSONVersioned(ctx, "cfg", 2, map[string]int{"schema_version": 2}); !errors.Is(err, storage.ErrNewerVersion) {
+			t.Fatalf("SetJSONVersioned over a newer record = %v, want ErrNewerVersion", err)
+		}
+		if err := s.SetJSONVersioned(ctx, "cfg", 3, map[string]int{"schema_version": 3}); err != nil {
+			t.Errorf("SetJSONVersioned over the same version: %v", err)
+		}
+	})
+}
+
+func TestBoltKeepsDataAcrossReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.db")
+	s, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt: %v", err)
+	}
+	if err := s.SetJSON(ctx, "k", "v", 0); err != nil {
+		t.Fatalf("SetJSON: %v", err)
+	}
+	if err := s.SAdd(ctx, "set", "a"); err != nil {
+		t.Fatalf("SAdd: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err = OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt: %v", err)
+	}
+	defer s.Close()
+	var v string
+	if found, err := s.GetJSON(ctx, "k", &v); !found || err != nil || v != "v" {
+		t.Errorf("GetJSON after reopening = %q, %v, %v; want v", v, found, err)
+	}
+	if members, err := s.SMembers(ctx, "set"); err != nil || !slices.Equal(members, []string{"a"}) {
+		t.Errorf("SMembers after reopening = %v, %v; want [a]", members, err)
+	}
+}
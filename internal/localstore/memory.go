@@ -0,0 +1,55 @@
+package localstore
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryBackend keeps entries in a map. Its contents are lost when the process exits.
+type memoryBackend struct {
+	mu      sync.RWMutex
+	entries map[string]*entry
+}
+
+// NewMemory returns an empty in-memory store for tests.
+func NewMemory() *Store {
+	return &Store{b: &memoryBackend{entries: make(map[string]*entry)}}
+}
+
+func (m *memoryBackend) view(fn func(txn) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return fn(memoryTxn{m})
+}
+
+func (m *memoryBackend) update(fn func(txn) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fn(memoryTxn{m})
+}
+
+func (m *memoryBackend) close() error {
+	return nil
+}
+
+type memoryTxn struct {
+	m *memoryBackend
+}
+
+func (t memoryTxn) get(key string) (*entry, error) {
+	e, ok := t.m.entries[key]
+	if !ok || e.expired(time.Now()) {
+		return nil, nil
+	}
+	return e, nil
+}
+
+func (t memoryTxn) put(key string, e *entry) error {
+	t.m.entries[key] = e
+	return nil
+}
+
+func (t memoryTxn) del(key string) error {
+	delete(t.m.entries, key)
+	return nil
+}
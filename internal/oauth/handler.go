@@ -1,63 +1,486 @@
 package oauth
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"dify-wp-sync/internal/dify"
 	"dify-wp-sync/internal/logger"
 	"dify-wp-sync/internal/sites"
 )
 
-// AuthHandler handles the OAuth callback from WordPress.com.
-// When a user authorizes your application, WordPress.com redirects here with a code.
-// AuthHandler exchanges the code for a token, creates a Dify dataset, and stores the site config.
+// stateCookie binds a connect flow to the browser that started it. It holds the nonce
+// of the flow's state, and the callback and selection page refuse states it does not
+// match, so a link or code from someone else's flow cannot be completed in it.
+const stateCookie = "dify_wp_sync_oauth"
+
+// AuthHandler runs the WordPress.com connect flow. /oauth/start, which takes the admin
+// token, records what the operator asked for under a signed state value, binds it to
+// the browser with a cookie and redirects to WordPress.com; /oauth/begin does the same
+// for a state issued by the CLI. The callback verifies and consumes that state, exchanges the code for a token, creates
+// a Dify dataset unless one was requested, stores the site config and redirects to
+// /oauth/result. Global tokens go through /oauth/select first, where the operator
+// picks the sites to connect; those sites share one stored token.
 type AuthHandler struct {
 	Oauth    *OAuthManager
 	SitesMgr *sites.Manager
 	DifyCli  *dify.DifyClient
+	States   *StateStore
+
+	// AdminToken is required to start a flow at /oauth/start, as a bearer token or as
+	// the password of HTTP basic authentication. The endpoint is disabled if it is empty.
+	AdminToken string
+}
+
+// HandleStart begins a connect flow. The optional post_types (comma-separated) and
+// dataset query parameters are applied to the site once it is connected; fresh=1
+// starts an already registered site over instead of keeping its data. global=1 asks
+// for a token covering all of the user's sites; the sites to connect are then given
+// as sites (comma-separated IDs) or picked on a selection page after authorizing.
+func (ah *AuthHandler) HandleStart(w http.ResponseWriter, r *http.Request) {
+	if ah.AdminToken == "" {
+		http.Error(w, "Connecting sites from the browser is disabled; set ADMIN_API_TOKEN", http.StatusForbidden)
+		return
+	}
+	if !ah.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="dify-wp-sync", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	q := r.URL.Query()
+	fresh, _ := strconv.ParseBool(q.Get("fresh"))
+	global, _ := strconv.ParseBool(q.Get("global"))
+	intent := &Intent{DatasetID: q.Get("dataset"), Fresh: fresh, Global: global}
+	if pt := q.Get("post_types"); pt != "" {
+		intent.PostTypes = strings.Split(pt, ",")
+	}
+	if ids := q.Get("sites"); ids != "" {
+		intent.SiteIDs = strings.Split(ids, ",")
+	}
+	state, err := ah.States.Create(r.Context(), intent)
+	if err != nil {
+		logger.Log.Errorf("Failed to store OAuth state: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	ah.bindState(w, state)
+	http.Redirect(w, r, ah.Oauth.AuthorizeURL(state, intent.Scope()), http.StatusFound)
 }
 
-// HandleOAuthCallback processes the authorization code returned by WordPress.com,
-// exchanges it for an access token, and registers the site in Redis with a corresponding Dify dataset.
+// HandleBegin starts a connect flow whose state was issued by the CLI (see
+// OAuthManager.BeginURL). The first browser to open the link claims the flow; opening
+// it again, here or elsewhere, is refused.
+func (ah *AuthHandler) HandleBegin(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	intent, err := ah.States.Claim(r.Context(), state)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidState) {
+			logger.Log.Errorf("Failed to claim OAuth state: %v", err)
+		}
+		redirectResult(w, r, url.Values{"error": {"This authorization link is invalid, has expired or was already opened. Start again."}})
+		return
+	}
+	ah.bindState(w, state)
+	http.Redirect(w, r, ah.Oauth.AuthorizeURL(state, intent.Scope()), http.StatusFound)
+}
+
+// authorized reports whether r carries the admin token, either as a bearer token or
+// as the password of HTTP basic authentication so a browser can prompt for it.
+func (ah *AuthHandler) authorized(r *http.Request) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := r.BasicAuth(); ok {
+		got = password
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(ah.AdminToken)) == 1
+}
+
+// bindState sets the cookie binding the flow of state to this browser.
+func (ah *AuthHandler) bindState(w http.ResponseWriter, state string) {
+	nonce, _, _ := strings.Cut(state, ".")
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    nonce,
+		Path:     "/oauth/",
+		MaxAge:   int(StateTTL / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(ah.Oauth.RedirectURI, "https://"),
+		// Lax, as the callback is a top-level redirect from WordPress.com.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// boundState reports whether state belongs to the flow this browser started.
+func boundState(r *http.Request, state string) bool {
+	c, err := r.Cookie(stateCookie)
+	nonce, _, _ := strings.Cut(state, ".")
+	return err == nil && nonce != "" && subtle.ConstantTimeCompare([]byte(c.Value), []byte(nonce)) == 1
+}
+
+// HandleOAuthCallback processes the authorization code returned by WordPress.com for
+// a state issued by HandleStart. Single-site tokens are connected right away; global
+// tokens continue on the selection page unless the intent names the sites.
 func (ah *AuthHandler) HandleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	if errVal := r.URL.Query().Get("error"); errVal != "" {
-		http.Error(w, "User denied access", http.StatusUnauthorized)
+	q := r.URL.Query()
+	if !boundState(r, q.Get("state")) {
+		redirectResult(w, r, url.Values{"error": {"This authorization was not started in this browser. Start again."}})
 		return
 	}
-	code := r.URL.Query().Get("code")
+	intent, err := ah.States.Consume(ctx, q.Get("state"))
+	if err != nil {
+		if !errors.Is(err, ErrInvalidState) {
+			logger.Log.Errorf("Failed to verify OAuth state: %v", err)
+		}
+		redirectResult(w, r, url.Values{"error": {"This authorization link is invalid or has expired. Start again."}})
+		return
+	}
+	if q.Get("error") != "" {
+		redirectResult(w, r, url.Values{"error": {"Access was denied."}})
+		return
+	}
+	code := q.Get("code")
 	if code == "" {
-		http.Error(w, "No code provided", http.StatusBadRequest)
+		redirectResult(w, r, url.Values{"error": {"No authorization code was provided."}})
 		return
 	}
 
 	tr, err := ah.Oauth.ExchangeCodeForToken(code)
 	if err != nil {
 		logger.Log.Errorf("Error exchanging code for token: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		redirectResult(w, r, url.Values{"error": {"Connecting the site failed."}})
+		return
+	}
+	if !tr.Global() {
+		sc, err := ah.connect(ctx, grant{SiteID: tr.BlogID, BlogURL: tr.BlogURL, Token: tr.AccessToken}, intent)
+		if err != nil {
+			logger.Log.Errorf("Failed to connect site: %v", err)
+			redirectResult(w, r, url.Values{"error": {"Connecting the site failed."}})
+			return
+		}
+		redirectResult(w, r, url.Values{"site": {sc.BlogURL}, "dataset": {sc.DifyDatasetID}})
+		return
+	}
+
+	user, err := ah.Oauth.Me(tr.AccessToken)
+	if err != nil {
+		logger.Log.Errorf("Failed to look up the user of a global token: %v", err)
+		redirectResult(w, r, url.Values{"error": {"Connecting the sites failed."}})
+		return
+	}
+	intent.TokenID = fmt.Sprintf("wpcom-user-%d", user.ID)
+	if len(intent.SiteIDs) > 0 {
+		connected, err := ah.connectShared(ctx, intent.TokenID, tr.AccessToken, intent.SiteIDs, intent)
+		redirectResult(w, r, sharedResult(len(intent.SiteIDs), connected, err))
 		return
 	}
 
-	datasetID, err := ah.DifyCli.CreateDataset(tr.BlogURL)
+	// Park the token until the sites are picked; it only becomes the user's shared
+	// token once at least one site uses it.
+	if intent.PendingTokenID, err = randomID(); err == nil {
+		intent.PendingTokenID = "pending-" + intent.PendingTokenID
+		err = ah.SitesMgr.SaveSharedToken(ctx, intent.PendingTokenID, tr.AccessToken, StateTTL)
+	}
+	var state string
+	if err == nil {
+		state, err = ah.States.Create(ctx, intent)
+	}
+	if err != nil {
+		logger.Log.Errorf("Failed to store global token for site selection: %v", err)
+		redirectResult(w, r, url.Values{"error": {"Connecting the sites failed."}})
+		return
+	}
+	ah.bindState(w, state)
+	http.Redirect(w, r, "/oauth/select?"+url.Values{"state": {state}}.Encode(), http.StatusFound)
+}
+
+var selectPage = template.Must(template.New("select").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Choose sites to sync</title></head>
+<body>
+<h1>Choose sites to sync</h1>
+<form method="post" action="/oauth/select">
+<input type="hidden" name="state" value="{{.State}}">
+{{range .Sites}}<p><label><input type="checkbox" name="site" value="{{.ID}}"> {{.Name}} ({{.URL}}){{if .Registered}} &mdash; already registered, will be reconnected{{end}}</label></p>
+{{else}}<p>This account has no sites.</p>
+{{end}}<p><button type="submit">Connect selected sites</button></p>
+</form>
+</body></html>
+`))
+
+// HandleSelect lets the operator pick which sites a global token connects: GET lists
+// the user's sites and POST connects the chosen ones.
+func (ah *AuthHandler) HandleSelect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if !boundState(r, r.PostForm.Get("state")) {
+			redirectResult(w, r, url.Values{"error": {"This selection was not started in this browser. Start again."}})
+			return
+		}
+		intent, err := ah.States.Consume(ctx, r.PostForm.Get("state"))
+		if err != nil || intent.PendingTokenID == "" {
+			redirectResult(w, r, url.Values{"error": {"This selection link is invalid or has expired. Start again."}})
+			return
+		}
+		if len(r.PostForm["site"]) == 0 {
+			redirectResult(w, r, url.Values{"error": {"No sites were selected."}})
+			return
+		}
+		token, err := ah.SitesMgr.SharedToken(ctx, intent.PendingTokenID)
+		if err != nil {
+			logger.Log.Errorf("Failed to load global token for site selection: %v", err)
+			redirectResult(w, r, url.Values{"error": {"This selection link has expired. Start again."}})
+			return
+		}
+		connected, err := ah.connectShared(ctx, intent.TokenID, token, r.PostForm["site"], intent)
+		redirectResult(w, r, sharedResult(len(r.PostForm["site"]), connected, err))
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	if !boundState(r, state) {
+		redirectResult(w, r, url.Values{"error": {"This selection was not started in this browser. Start again."}})
+		return
+	}
+	intent, err := ah.States.Peek(ctx, state)
+	if err != nil || intent.PendingTokenID == "" {
+		redirectResult(w, r, url.Values{"error": {"This selection link is invalid or has expired. Start again."}})
+		return
+	}
+	token, err := ah.SitesMgr.SharedToken(ctx, intent.PendingTokenID)
+	var userSites []UserSite
+	if err == nil {
+		userSites, err = ah.Oauth.ListUserSites(token)
+	}
 	if err != nil {
-		logger.Log.Errorf("Failed to create Dify dataset: %v", err)
-		http.Error(w, "Failed to create dataset", http.StatusInternalServerError)
+		logger.Log.Errorf("Failed to list sites of a global token: %v", err)
+		http.Error(w, "Failed to list your sites", http.StatusBadGateway)
 		return
 	}
 
+	type option struct {
+		UserSite
+		Registered bool
+	}
+	data := struct {
+		State string
+		Sites []option
+	}{State: state}
+	for _, s := range userSites {
+		_, err := ah.SitesMgr.GetSite(ctx, s.SiteID())
+		data.Sites = append(data.Sites, option{UserSite: s, Registered: err == nil})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := selectPage.Execute(w, data); err != nil {
+		logger.Log.Errorf("Failed to render site selection: %v", err)
+	}
+}
+
+// grant is a token and the site it was granted for. TokenID is set when the token is
+// a shared global token.
+type grant struct {
+	SiteID  string
+	BlogURL string
+	Token   string
+	TokenID string
+}
+
+// connectShared stores a global token as the shared token tokenID and connects each
+// of siteIDs with it. The sites must be among those the token gives access to. It
+// returns the sites connected so far along with any failures.
+func (ah *AuthHandler) connectShared(ctx context.Context, tokenID, token string, siteIDs []string, intent *Intent) ([]*sites.SiteConfig, error) {
+	userSites, err := ah.Oauth.ListUserSites(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sites of global token: %w", err)
+	}
+	urls := make(map[string]string)
+	for _, s := range userSites {
+		urls[s.SiteID()] = s.URL
+	}
+	if err := ah.SitesMgr.SaveSharedToken(ctx, tokenID, token, 0); err != nil {
+		return nil, fmt.Errorf("failed to store shared token: %w", err)
+	}
+
+	var connected []*sites.SiteConfig
+	var errs []error
+	for _, id := range siteIDs {
+		blogURL, ok := urls[id]
+		if !ok {
+			errs = append(errs, fmt.Errorf("site %s is not accessible with this token", id))
+			continue
+		}
+		sc, err := ah.connect(ctx, grant{SiteID: id, BlogURL: blogURL, Token: token, TokenID: tokenID}, intent)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("site %s: %w", id, err))
+			continue
+		}
+		connected = append(connected, sc)
+	}
+	return connected, errors.Join(errs...)
+}
+
+// sharedResult returns the result page parameters for connectShared's outcome for
+// selected sites.
+func sharedResult(selected int, connected []*sites.SiteConfig, err error) url.Values {
+	params := url.Values{}
+	for _, sc := range connected {
+		params.Add("site", sc.BlogURL)
+		params.Add("dataset", sc.DifyDatasetID)
+	}
+	if err != nil {
+		logger.Log.Errorf("Failed to connect sites: %v", err)
+		params.Set("error", fmt.Sprintf("%d of %d selected sites could not be connected.", selected-len(connected), selected))
+	}
+	return params
+}
+
+// reconnectLockWait is how long a reconnect waits for a running sync of the site.
+const reconnectLockWait = time.Minute
+
+// connect registers the site g grants access to, applying intent. A site that is
+// already registered only gets the new token and is reactivated, keeping its dataset,
+// mapping, post types and sync time, unless intent.Fresh is set.
+func (ah *AuthHandler) connect(ctx context.Context, g grant, intent *Intent) (*sites.SiteConfig, error) {
+	_, err := ah.SitesMgr.GetSite(ctx, g.SiteID)
+	if err == nil {
+		return ah.reconnect(ctx, g, intent)
+	}
+	if !errors.Is(err, sites.ErrSiteNotFound) {
+		// Anything but a missing record must not be mistaken for a new site, or the
+		// existing config would be overwritten and its dataset orphaned.
+		return nil, fmt.Errorf("failed to look up site %s: %w", g.SiteID, err)
+	}
+
+	datasetID, err := ah.dataset(ctx, g, intent)
+	if err != nil {
+		return nil, err
+	}
 	sc := &sites.SiteConfig{
-		SiteID:        tr.BlogID,
-		AccessToken:   tr.AccessToken,
-		BlogURL:       tr.BlogURL,
+		SiteID:        g.SiteID,
+		AccessToken:   g.Token,
+		TokenID:       g.TokenID,
+		BlogURL:       g.BlogURL,
 		DifyDatasetID: datasetID,
+		PostTypes:     intent.PostTypes,
 	}
-
 	if err := ah.SitesMgr.AddSite(ctx, sc); err != nil {
-		logger.Log.Errorf("Failed to store site config: %v", err)
-		http.Error(w, "Failed to store site config", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("failed to store site config: %w", err)
+	}
+	logger.Log.Infof("Connected site %s (%s) with dataset %s", sc.SiteID, sc.BlogURL, datasetID)
+	return sc, nil
+}
+
+// reconnect stores a new token for a registered site. With intent.Fresh the site is
+// reset as if it were new: it gets a new (or the requested) dataset, an empty mapping
+// and no sync time, and the old dataset is left alone.
+func (ah *AuthHandler) reconnect(ctx context.Context, g grant, intent *Intent) (*sites.SiteConfig, error) {
+	lock, err := ah.SitesMgr.LockSite(ctx, g.SiteID, "oauth-reconnect", reconnectLockWait)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	sc, err := ah.SitesMgr.GetSite(ctx, g.SiteID)
+	if err != nil {
+		return nil, err
+	}
+	if sc.Source() != sites.SourceWPCom && !intent.Fresh {
+		return nil, fmt.Errorf("site %s is registered as a %s site; reconnect with fresh to replace it", sc.SiteID, sc.Source())
 	}
 
-	fmt.Fprintf(w, "Site connected: %s (dataset: %s)", tr.BlogURL, datasetID)
+	oldTokenID := sc.TokenID
+	sc.AccessToken = g.Token
+	sc.TokenID = g.TokenID
+	sc.BlogURL = g.BlogURL
+	sc.Status = sites.StatusActive
+	if intent.Fresh {
+		oldDatasetID := sc.DifyDatasetID
+		if sc.DifyDatasetID, err = ah.dataset(ctx, g, intent); err != nil {
+			return nil, err
+		}
+		if err := ah.SitesMgr.ClearDocIDs(ctx, sc); err != nil {
+			return nil, err
+		}
+		sc.SourceType = sites.SourceWPCom
+		sc.PostTypes = intent.PostTypes
+		sc.LastSyncTime = time.Time{}
+		sc.FeedETags = nil
+		logger.Log.Infof("Reconnected site %s (%s) fresh with dataset %s; previous dataset %s was left in place",
+			sc.SiteID, sc.BlogURL, sc.DifyDatasetID, oldDatasetID)
+	} else {
+		if intent.DatasetID != "" || len(intent.PostTypes) > 0 {
+			logger.Log.Warnf("Ignoring requested dataset and post types for already registered site %s", sc.SiteID)
+		}
+		logger.Log.Infof("Reconnected site %s (%s), keeping dataset %s and %d mapped documents",
+			sc.SiteID, sc.BlogURL, sc.DifyDatasetID, len(sc.PostDocMapping))
+	}
+
+	if err := ah.SitesMgr.UpdateSiteLocked(ctx, sc, lock); err != nil {
+		return nil, fmt.Errorf("failed to store site config: %w", err)
+	}
+	if oldTokenID != "" && oldTokenID != sc.TokenID {
+		// The site no longer refers to its previous shared token; drop it if it was the last.
+		if err := ah.SitesMgr.DeleteUnusedSharedToken(ctx, oldTokenID); err != nil {
+			logger.Log.Warnf("Failed to clean up previous shared token of site %s: %v", sc.SiteID, err)
+		}
+	}
+	return sc, nil
+}
+
+// dataset returns the dataset a newly connected site syncs into: the one requested
+// in intent, which must exist, or a new one named after the blog.
+func (ah *AuthHandler) dataset(ctx context.Context, g grant, intent *Intent) (string, error) {
+	datasetID := intent.DatasetID
+	if datasetID != "" {
+		exists, err := ah.DifyCli.DatasetExists(ctx, datasetID)
+		if err != nil {
+			return "", fmt.Errorf("failed to check dataset %s: %w", datasetID, err)
+		}
+		if !exists {
+			return "", fmt.Errorf("dataset %s does not exist", datasetID)
+		}
+		return datasetID, nil
+	}
+	datasetID, err := ah.DifyCli.CreateDataset(ctx, g.BlogURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Dify dataset: %w", err)
+	}
+	return datasetID, nil
+}
+
+func redirectResult(w http.ResponseWriter, r *http.Request, params url.Values) {
+	http.Redirect(w, r, "/oauth/result?"+params.Encode(), http.StatusFound)
+}
+
+// HandleResult shows the outcome of a connect flow: the sites connected, each with its
+// dataset, and the error if anything failed.
+func (ah *AuthHandler) HandleResult(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	q := r.URL.Query()
+	connected, datasets := q["site"], q["dataset"]
+	if msg := q.Get("error"); msg != "" && len(connected) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Site not connected: %s\n", msg)
+		return
+	}
+	for i, site := range connected {
+		var datasetID string
+		if i < len(datasets) {
+			datasetID = datasets[i]
+		}
+		fmt.Fprintf(w, "Site connected: %s (dataset: %s)\n", site, datasetID)
+	}
+	if msg := q.Get("error"); msg != "" {
+		fmt.Fprintf(w, "Some sites were not connected: %s\n", msg)
+	}
 }
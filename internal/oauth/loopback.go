@@ -0,0 +1,100 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"dify-wp-sync/internal/logger"
+)
+
+// ConnectLoopback runs a whole connect flow from a command-line tool: it serves the
+// callback on ln (a loopback listener on a port registered as a redirect URI), stores
+// intent under a state value, passes the link that starts the flow in a browser (see
+// OAuthManager.BeginURL) to show and waits until WordPress.com has redirected back
+// and the site is registered, or ctx is done. Global tokens are taken through the
+// selection page on the same listener. Requests whose state does not belong to the
+// flow, or that come from another browser, are refused without ending the wait. It
+// returns the outcome shown on the result page: the connected sites' URLs ("site")
+// and datasets ("dataset"). If some sites failed, the error is returned along with
+// the sites that were connected.
+func ConnectLoopback(ctx context.Context, ah *AuthHandler, ln net.Listener, intent *Intent, show func(beginURL string)) (url.Values, error) {
+	om := *ah.Oauth
+	om.RedirectURI = fmt.Sprintf("http://%s/oauth/callback", ln.Addr().String())
+	local := *ah
+	local.Oauth = &om
+
+	state, err := local.States.Create(ctx, intent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store OAuth state: %w", err)
+	}
+
+	done := make(chan url.Values, 1)
+	shown := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/begin", local.HandleBegin)
+	mux.HandleFunc("/oauth/callback", loopbackStep(local.HandleOAuthCallback, state, done))
+	mux.HandleFunc("/oauth/select", loopbackStep(local.HandleSelect, "", done))
+	mux.HandleFunc("/oauth/result", func(w http.ResponseWriter, r *http.Request) {
+		local.HandleResult(w, r)
+		select {
+		case shown <- struct{}{}:
+		default:
+		}
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	show(om.BeginURL(state))
+
+	select {
+	case outcome := <-done:
+		// Let the browser load the result page before shutting down.
+		select {
+		case <-shown:
+		case <-time.After(5 * time.Second):
+		}
+		if msg := outcome.Get("error"); msg != "" {
+			return outcome, errors.New(msg)
+		}
+		return outcome, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// loopbackStep wraps a step of a loopback flow. It refuses requests whose state is
+// not bound to the browser that started the flow, or is not expected when expected
+// is set, and passes the outcome to done once next redirects to the result page.
+func loopbackStep(next http.HandlerFunc, expected string, done chan<- url.Values) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		state := r.Form.Get("state")
+		if (expected != "" && state != expected) || !boundState(r, state) {
+			logger.Log.Warnf("Ignoring a request to %s that does not belong to the connect flow in progress", r.URL.Path)
+			http.Error(w, "This request does not belong to the connect flow in progress.", http.StatusBadRequest)
+			return
+		}
+		next(w, r)
+		if loc, ok := strings.CutPrefix(w.Header().Get("Location"), "/oauth/result?"); ok {
+			outcome, _ := url.ParseQuery(loc)
+			select {
+			case done <- outcome:
+			default:
+			}
+		}
+	}
+}
@@ -3,23 +3,103 @@ package oauth
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
+
+	"dify-wp-sync/internal/wpcom"
 )
 
+// ErrInvalidToken is returned by CheckToken for tokens that were revoked, expired or
+// belong to another app or site.
+var ErrInvalidToken = errors.New("token is no longer valid")
+
+// ScopeGlobal requests a token for every site of the user instead of a single blog.
+const ScopeGlobal = "global"
+
 type OAuthManager struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURI  string
+
+	oauthBase  string // authorize, token and token-info endpoints
+	apiBase    string // REST API for /me and /me/sites
+	httpClient *http.Client
+}
+
+// Option customizes an OAuthManager.
+type Option func(*OAuthManager)
+
+// WithOAuthBase makes the manager use the OAuth endpoints under oauthBase instead of
+// wpcom.DefaultOAuthBase. An empty oauthBase keeps the default.
+func WithOAuthBase(oauthBase string) Option {
+	return func(o *OAuthManager) {
+		if oauthBase != "" {
+			o.oauthBase = strings.TrimRight(oauthBase, "/")
+		}
+	}
+}
+
+// WithAPIBase makes the manager use the REST API at apiBase instead of
+// wpcom.DefaultAPIBase. An empty apiBase keeps the default.
+func WithAPIBase(apiBase string) Option {
+	return func(o *OAuthManager) {
+		if apiBase != "" {
+			o.apiBase = strings.TrimRight(apiBase, "/")
+		}
+	}
+}
+
+// WithHTTPClient makes the manager send its requests through httpClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *OAuthManager) {
+		o.httpClient = httpClient
+	}
 }
 
-func NewOAuthManager(clientID, clientSecret, redirectURI string) *OAuthManager {
-	return &OAuthManager{
+func NewOAuthManager(clientID, clientSecret, redirectURI string, opts ...Option) *OAuthManager {
+	o := &OAuthManager{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		RedirectURI:  redirectURI,
+		oauthBase:    wpcom.DefaultOAuthBase,
+		apiBase:      wpcom.DefaultAPIBase,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
 	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// AuthorizeURL returns the WordPress.com authorize URL for a connect flow identified by
+// state. An empty scope asks for a token for the single blog the user picks.
+func (o *OAuthManager) AuthorizeURL(state, scope string) string {
+	params := url.Values{}
+	params.Set("client_id", o.ClientID)
+	params.Set("redirect_uri", o.RedirectURI)
+	params.Set("response_type", "code")
+	params.Set("state", state)
+	if scope != "" {
+		params.Set("scope", scope)
+	}
+	return o.oauthBase + "/authorize?" + params.Encode()
+}
+
+// BeginURL returns the link that starts a connect flow whose state was issued outside
+// a browser. It points at /oauth/begin next to the redirect URI, which binds the flow
+// to the browser that opens it and then redirects to AuthorizeURL.
+func (o *OAuthManager) BeginURL(state string) string {
+	u, err := url.Parse(o.RedirectURI)
+	if err != nil {
+		return o.RedirectURI
+	}
+	u.Path = "/oauth/begin"
+	u.RawQuery = url.Values{"state": {state}}.Encode()
+	return u.String()
 }
 
 func (o *OAuthManager) ExchangeCodeForToken(code string) (*TokenResponse, error) {
@@ -31,14 +111,13 @@ func (o *OAuthManager) ExchangeCodeForToken(code string) (*TokenResponse, error)
 	form.Set("code", code)
 	form.Set("grant_type", "authorization_code")
 
-	req, err := http.NewRequest("POST", "https://public-api.wordpress.com/oauth2/token", bytes.NewBufferString(form.Encode()))
+	req, err := http.NewRequest("POST", o.oauthBase+"/token", bytes.NewBufferString(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := o.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -54,3 +133,75 @@ func (o *OAuthManager) ExchangeCodeForToken(code string) (*TokenResponse, error)
 	}
 	return &tr, nil
 }
+
+// Me returns the user token belongs to.
+func (o *OAuthManager) Me(token string) (*User, error) {
+	var u User
+	if err := o.getJSON(o.apiBase+"/me?fields=ID,username", token, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListUserSites returns the sites a global token gives access to.
+func (o *OAuthManager) ListUserSites(token string) ([]UserSite, error) {
+	var resp struct {
+		Sites []UserSite `json:"sites"`
+	}
+	if err := o.getJSON(o.apiBase+"/me/sites?fields=ID,name,URL", token, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sites, nil
+}
+
+func (o *OAuthManager) getJSON(apiURL, token string, dest interface{}) error {
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, req.URL.Path)
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// CheckToken asks /oauth2/token-info whether token is still valid for this app and,
+// unless it is a global token, for the site siteID. It returns ErrInvalidToken if not.
+func (o *OAuthManager) CheckToken(token, siteID string) error {
+	params := url.Values{}
+	params.Set("client_id", o.ClientID)
+	params.Set("token", token)
+	resp, err := o.httpClient.Get(o.oauthBase + "/token-info?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("token-info returned status code %d: %w", resp.StatusCode, ErrInvalidToken)
+	default:
+		return fmt.Errorf("unexpected status code %d from token-info endpoint", resp.StatusCode)
+	}
+
+	var info TokenInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return err
+	}
+	if string(info.ClientID) != o.ClientID {
+		return fmt.Errorf("token was issued to client %s: %w", info.ClientID, ErrInvalidToken)
+	}
+	if info.Scope != ScopeGlobal && info.BlogID != "" && info.BlogID != "0" && string(info.BlogID) != siteID {
+		return fmt.Errorf("token is for blog %s, not %s: %w", info.BlogID, siteID, ErrInvalidToken)
+	}
+	return nil
+}
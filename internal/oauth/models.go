@@ -1,8 +1,54 @@
 package oauth
 
+import (
+	"strconv"
+	"strings"
+)
+
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
 	BlogID      string `json:"blog_id"`
 	BlogURL     string `json:"blog_url"`
 	TokenType   string `json:"token_type"`
+	Scope       string `json:"scope"`
+}
+
+// Global reports whether the token covers every site of the user rather than the
+// single blog it names.
+func (tr *TokenResponse) Global() bool {
+	return tr.Scope == ScopeGlobal || tr.BlogID == "" || tr.BlogID == "0"
+}
+
+// User is the WordPress.com account a token belongs to.
+type User struct {
+	ID       int64  `json:"ID"`
+	Username string `json:"username"`
+}
+
+// UserSite is a site listed by /me/sites.
+type UserSite struct {
+	ID   int64  `json:"ID"`
+	Name string `json:"name"`
+	URL  string `json:"URL"`
+}
+
+// SiteID returns the site ID in the form site configs use.
+func (s UserSite) SiteID() string {
+	return strconv.FormatInt(s.ID, 10)
+}
+
+// TokenInfo is what /oauth2/token-info reports about a token.
+type TokenInfo struct {
+	ClientID flexID `json:"client_id"`
+	UserID   flexID `json:"user_id"`
+	BlogID   flexID `json:"blog_id"`
+	Scope    string `json:"scope"`
+}
+
+// flexID is an ID the API returns either as a number or as a string.
+type flexID string
+
+func (f *flexID) UnmarshalJSON(b []byte) error {
+	*f = flexID(strings.Trim(string(b), `"`))
+	return nil
 }
@@ -0,0 +1,178 @@
+package oauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"dify-wp-sync/internal/storage"
+)
+
+// StateTTL is how long a started connect flow can take to come back to the callback.
+const StateTTL = 15 * time.Minute
+
+// ErrInvalidState is returned for state values that were not issued by us, have
+// expired or were already used.
+var ErrInvalidState = errors.New("invalid, expired or already used OAuth state")
+
+// Intent is what the operator asked for when starting a connect flow. It is stored
+// server-side; WordPress.com only sees the opaque state value referring to it.
+type Intent struct {
+	PostTypes []string  `json:"post_types,omitempty"`
+	DatasetID string    `json:"dataset_id,omitempty"` // Sync into this dataset instead of creating one
+	Fresh     bool      `json:"fresh,omitempty"`      // Start over if the site is already registered
+	Global    bool      `json:"global,omitempty"`     // Ask for a global token and connect several sites with it
+	SiteIDs   []string  `json:"site_ids,omitempty"`   // Sites to connect with a global token; chosen on the selection page if empty
+	CreatedAt time.Time `json:"created_at"`
+
+	// Set on the selection step of a global token: the shared token ID the chosen sites
+	// get, and where the token waits until they are chosen.
+	TokenID        string `json:"token_id,omitempty"`
+	PendingTokenID string `json:"pending_token_id,omitempty"`
+}
+
+// Scope returns the OAuth scope to request for the intent.
+func (i *Intent) Scope() string {
+	if i.Global {
+		return ScopeGlobal
+	}
+	return ""
+}
+
+// StateStore issues and consumes the OAuth state parameter. A state is
+// "<nonce>.<HMAC of nonce>", so forged values are rejected before touching storage,
+// and each nonce can be consumed once.
+type StateStore struct {
+	store  storage.Store
+	secret []byte
+}
+
+func NewStateStore(store storage.Store, secret string) *StateStore {
+	return &StateStore{store: store, secret: []byte(secret)}
+}
+
+func stateKey(nonce string) string {
+	return fmt.Sprintf("wp_oauth_state:%s", nonce)
+}
+
+func stateUsedKey(nonce string) string {
+	return fmt.Sprintf("wp_oauth_state_used:%s", nonce)
+}
+
+func stateClaimedKey(nonce string) string {
+	return fmt.Sprintf("wp_oauth_state_claimed:%s", nonce)
+}
+
+func (s *StateStore) sign(nonce string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomID returns 16 random bytes, hex-encoded.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Create stores intent and returns the state value to send to the authorize endpoint.
+func (s *StateStore) Create(ctx context.Context, intent *Intent) (string, error) {
+	nonce, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	intent.CreatedAt = time.Now().UTC()
+	if err := s.store.SetJSON(ctx, stateKey(nonce), intent, StateTTL); err != nil {
+		return "", err
+	}
+	return nonce + "." + s.sign(nonce), nil
+}
+
+// verify checks the signature of state and returns its nonce.
+func (s *StateStore) verify(state string) (string, error) {
+	nonce, sig, ok := strings.Cut(state, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(nonce))) {
+		return "", ErrInvalidState
+	}
+	return nonce, nil
+}
+
+// Peek verifies state and returns its intent without consuming it.
+func (s *StateStore) Peek(ctx context.Context, state string) (*Intent, error) {
+	nonce, err := s.verify(state)
+	if err != nil {
+		return nil, err
+	}
+	var used int
+	found, err := s.store.GetJSON(ctx, stateUsedKey(nonce), &used)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, ErrInvalidState
+	}
+	var intent Intent
+	found, err = s.store.GetJSON(ctx, stateKey(nonce), &intent)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrInvalidState
+	}
+	return &intent, nil
+}
+
+// Claim verifies a state that was issued outside a browser, such as a link printed by
+// the CLI, and returns its intent. Only the first caller can claim a state; the
+// browser that does is the one the flow is then bound to.
+func (s *StateStore) Claim(ctx context.Context, state string) (*Intent, error) {
+	nonce, err := s.verify(state)
+	if err != nil {
+		return nil, err
+	}
+	first, err := s.store.SetNX(ctx, stateClaimedKey(nonce), 1, StateTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, ErrInvalidState
+	}
+	return s.Peek(ctx, state)
+}
+
+// Consume verifies state and returns its intent. A state can be consumed only once.
+func (s *StateStore) Consume(ctx context.Context, state string) (*Intent, error) {
+	nonce, err := s.verify(state)
+	if err != nil {
+		return nil, err
+	}
+	first, err := s.store.SetNX(ctx, stateUsedKey(nonce), 1, StateTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, ErrInvalidState
+	}
+
+	var intent Intent
+	found, err := s.store.GetJSON(ctx, stateKey(nonce), &intent)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrInvalidState
+	}
+	if err := s.store.Del(ctx, stateKey(nonce)); err != nil {
+		return nil, err
+	}
+	return &intent, nil
+}
@@ -0,0 +1,102 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"dify-wp-sync/internal/localstore"
+)
+
+func TestStateCarriesIntentAndIsConsumedOnce(t *testing.T) {
+	ctx := context.Background()
+	s := NewStateStore(localstore.NewMemory(), "state-secret")
+	state, err := s.Create(ctx, &Intent{PostTypes: []string{"post", "page"}, DatasetID: "ds-1"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		intent, err := s.Peek(ctx, state)
+		if err != nil || intent.DatasetID != "ds-1" {
+			t.Fatalf("Peek %d = %+v, %v; want the stored intent", i+1, intent, err)
+		}
+	}
+	intent, err := s.Consume(ctx, state)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if intent.DatasetID != "ds-1" || !slices.Equal(intent.PostTypes, []string{"post", "page"}) || intent.CreatedAt.IsZero() {
+		t.Errorf("Consume = %+v, want the stored intent", intent)
+	}
+
+	if _, err := s.Consume(ctx, state); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("second Consume = %v, want ErrInvalidState", err)
+	}
+	if _, err := s.Peek(ctx, state); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("Peek after Consume = %v, want ErrInvalidState", err)
+	}
+}
+
+func TestForgedStatesAreRejected(t *testing.T) {
+	ctx := context.Background()
+	store := localstore.NewMemory()
+	s := NewStateStore(store, "state-secret")
+	state, err := s.Create(ctx, &Intent{})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	nonce, sig, _ := strings.Cut(state, ".")
+	altered := "0" + nonce[1:]
+	if altered == nonce {
+		altered = "1" + nonce[1:]
+	}
+	otherKey, err := NewStateStore(store, "other-secret").Create(ctx, &Intent{})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	for name, forged := range map[string]string{
+		"unsigned":          nonce,
+		"empty signature":   nonce + ".",
+		"altered nonce":     altered + "." + sig,
+		"altered signature": nonce + "." + strings.Repeat("0", len(sig)),
+		"other secret":      otherKey,
+		"never issued":      "unknown." + s.sign("unknown"),
+	} {
+		if _, err := s.Peek(ctx, forged); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("Peek of %s state = %v, want ErrInvalidState", name, err)
+		}
+		if _, err := s.Consume(ctx, forged); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("Consume of %s state = %v, want ErrInvalidState", name, err)
+		}
+	}
+
+	// None of the rejected attempts used up the genuine state.
+	if _, err := s.Consume(ctx, state); err != nil {
+		t.Errorf("Consume of the genuine state: %v", err)
+	}
+}
+
+func TestStateCanBeClaimedOnce(t *testing.T) {
+	ctx := context.Background()
+	s := NewStateStore(localstore.NewMemory(), "state-secret")
+	state, err := s.Create(ctx, &Intent{DatasetID: "ds-1"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	intent, err := s.Claim(ctx, state)
+	if err != nil || intent.DatasetID != "ds-1" {
+		t.Fatalf("Claim = %+v, %v; want the stored intent", intent, err)
+	}
+	if _, err := s.Claim(ctx, state); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("second Claim = %v, want ErrInvalidState", err)
+	}
+	// Claiming binds the flow to a browser; the callback still consumes the state.
+	if _, err := s.Consume(ctx, state); err != nil {
+		t.Errorf("Consume after Claim: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/storage"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// acquireScript takes the lock at KEYS[1] if it is free and draws the next fencing
+// token from KEYS[2], so failed attempts never advance the token.
+var acquireScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+local token = redis.call("INCR", KEYS[2])
+redis.call("SET", KEYS[1], token .. ":" .. ARGV[1], "PX", ARGV[2])
+return token`)
+
+// renewScript extends the lease only if it is still held with our value.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseScript deletes the lease only if it is still held with our value.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// storedVersionLua defines stored_version(key), the schema_version of the JSON object
+// at key, or 0 if the key is missing or holds something else.
+const storedVersionLua = `
+local function stored_version(key)
+	local raw = redis.call("GET", key)
+	if not raw then
+		return 0
+	end
+	local ok, doc = pcall(cjson.decode, raw)
+	if ok and type(doc) == "table" and tonumber(doc["schema_version"]) then
+		return tonumber(doc["schema_version"])
+	end
+	return 0
+end
+`
+
+// fencedSetScript writes KEYS[2] only if no lease newer than ARGV[1] has been issued on
+// fence KEYS[1] and the value at KEYS[2] has no schema_version above ARGV[3].
+var fencedSetScript = redis.NewScript(storedVersionLua + `
+if tonumber(redis.call("GET", KEYS[1]) or "0") ~= tonumber(ARGV[1]) then
+	return 0
+end
+if stored_version(KEYS[2]) > tonumber(ARGV[3]) then
+	return -1
+end
+redis.call("SET", KEYS[2], ARGV[2])
+return 1`)
+
+// versionedSetScript writes KEYS[1] only if its value has no schema_version above ARGV[2].
+var versionedSetScript = redis.NewScript(storedVersionLua + `
+if stored_version(KEYS[1]) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1])
+return 1`)
+
+// lease is a storage.Lease held in Redis.
+type lease struct {
+	token    int64
+	store    *RedisStore
+	key      string
+	fenceKey string
+	value    string
+	ttl      time.Duration
+}
+
+// AcquireLease tries once to take the lock at key for ttl, returning ErrLocked if it is held.
+// fenceKey holds the counter the fencing token is drawn from.
+func (r *RedisStore) AcquireLease(ctx context.Context, key, fenceKey, owner string, ttl time.Duration) (storage.Lease, error) {
+	token, err := acquireScript.Run(ctx, r.client, []string{key, fenceKey}, owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return nil, err
+	}
+	if token == 0 {
+		return nil, storage.ErrLocked
+	}
+	value := fmt.Sprintf("%d:%s", token, owner)
+	return &lease{token: token, store: r, key: key, fenceKey: fenceKey, value: value, ttl: ttl}, nil
+}
+
+// LeaseHolder returns the owner description stored in the lock at key, or "" if it is free.
+func (r *RedisStore) LeaseHolder(ctx context.Context, key string) (string, error) {
+	v, err := r.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return v, err
+}
+
+func (l *lease) Token() int64 {
+	return l.token
+}
+
+// Renew extends the lease by its full TTL.
+func (l *lease) Renew(ctx context.Context) error {
+	n, err := renewScript.Run(ctx, l.store.client, []string{l.key}, l.value, l.ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrLeaseLost
+	}
+	return nil
+}
+
+// Release frees the lease if it is still held.
+func (l *lease) Release(ctx context.Context) error {
+	n, err := releaseScript.Run(ctx, l.store.client, []string{l.key}, l.value).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrLeaseLost
+	}
+	return nil
+}
+
+// SetJSONFenced writes value to key only while token is the latest token issued on fenceKey
+// and the value stored at key has no schema_version above maxVersion.
+func (r *RedisStore) SetJSONFenced(ctx context.Context, fenceKey string, token int64, key string, maxVersion int, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	n, err := fencedSetScript.Run(ctx, r.client, []string{fenceKey, key}, token, b, maxVersion).Int()
+	if err != nil {
+		return err
+	}
+	switch n {
+	case 0:
+		return storage.ErrFenced
+	case -1:
+		return storage.ErrNewerVersion
+	}
+	return nil
+}
+
+// SetJSONVersioned writes value to key unless the JSON object stored there has a
+// schema_version above maxVersion.
+func (r *RedisStore) SetJSONVersioned(ctx context.Context, key string, maxVersion int, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	n, err := versionedSetScript.Run(ctx, r.client, []string{key}, b, maxVersion).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNewerVersion
+	}
+	return nil
+}
@@ -22,6 +22,11 @@ func New(addr string, password string, db int) *RedisStore {
 	}
 }
 
+// Close closes the underlying Redis client.
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
+
 func (r *RedisStore) SetJSON(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
 	b, err := json.Marshal(value)
 	if err != nil {
@@ -71,3 +76,59 @@ func (r *RedisStore) SAdd(ctx context.Context, key string, members ...string) er
 func (r *RedisStore) SMembers(ctx context.Context, key string) ([]string, error) {
 	return r.client.SMembers(ctx, key).Result()
 }
+
+func (r *RedisStore) SRem(ctx context.Context, key string, members ...string) error {
+	return r.client.SRem(ctx, key, members).Err()
+}
+
+// SetNX sets key only if it does not exist yet, reporting whether it was set.
+func (r *RedisStore) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return r.client.SetNX(ctx, key, b, expiration).Result()
+}
+
+func (r *RedisStore) LPushJSON(ctx context.Context, key string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return r.client.LPush(ctx, key, b).Err()
+}
+
+func (r *RedisStore) LTrim(ctx context.Context, key string, start, stop int64) error {
+	return r.client.LTrim(ctx, key, start, stop).Err()
+}
+
+func (r *RedisStore) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return r.client.LRange(ctx, key, start, stop).Result()
+}
+
+func (r *RedisStore) HDel(ctx context.Context, key string, fields ...string) error {
+	return r.client.HDel(ctx, key, fields...).Err()
+}
+
+func (r *RedisStore) HReplaceJSON(ctx context.Context, key string, fields map[string]interface{}) error {
+	values := make([]interface{}, 0, 2*len(fields))
+	for field, value := range fields {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		values = append(values, field, b)
+	}
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		if len(values) > 0 {
+			pipe.HSet(ctx, key, values...)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *RedisStore) Del(ctx context.Context, keys ...string) error {
+	return r.client.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,132 @@
+package redisstore
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// streamField is the single field every stream entry written by this package carries.
+const streamField = "data"
+
+// StreamMessage is one entry read from a Redis stream.
+type StreamMessage struct {
+	ID   string
+	Data string
+	// Deliveries is how many times the entry has been delivered to a consumer of the
+	// group, including this one.
+	Deliveries int64
+}
+
+func toStreamMessages(msgs []redis.XMessage) []StreamMessage {
+	out := make([]StreamMessage, 0, len(msgs))
+	for _, m := range msgs {
+		data, _ := m.Values[streamField].(string)
+		out = append(out, StreamMessage{ID: m.ID, Data: data, Deliveries: 1})
+	}
+	return out
+}
+
+// XAdd appends data to stream, returning the entry ID.
+func (r *RedisStore) XAdd(ctx context.Context, stream, data string) (string, error) {
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		Values: map[string]interface{}{streamField: data},
+	}).Result()
+}
+
+// EnsureGroup creates a consumer group reading stream from the beginning, creating the
+// stream if needed. An existing group is left untouched.
+func (r *RedisStore) EnsureGroup(ctx context.Context, stream, group string) error {
+	err := r.client.XGroupCreateMkStream(ctx, stream, group, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// XReadGroup reads up to count new entries for consumer, blocking up to block.
+// It returns no messages and no error when the block times out.
+func (r *RedisStore) XReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]StreamMessage, error) {
+	res, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msgs []StreamMessage
+	for _, s := range res {
+		msgs = append(msgs, toStreamMessages(s.Messages)...)
+	}
+	return msgs, nil
+}
+
+// XAck acknowledges entries so they leave the group's pending list.
+func (r *RedisStore) XAck(ctx context.Context, stream, group string, ids ...string) error {
+	return r.client.XAck(ctx, stream, group, ids...).Err()
+}
+
+// XAckDel acknowledges entries and deletes them from stream in one transaction, for
+// streams read by a single group where an acknowledged entry is of no further use.
+// Without it the stream keeps every entry ever added.
+func (r *RedisStore) XAckDel(ctx context.Context, stream, group string, ids ...string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, stream, group, ids...)
+		pipe.XDel(ctx, stream, ids...)
+		return nil
+	})
+	return err
+}
+
+// XAutoClaim transfers up to count entries that have been pending longer than minIdle to
+// consumer, with their delivery counts read from the group's pending list.
+func (r *RedisStore) XAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]StreamMessage, error) {
+	msgs, _, err := r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    "0-0",
+		Count:    count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := toStreamMessages(msgs)
+	for i := range out {
+		pending, err := r.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  group,
+			Start:  out[i].ID,
+			End:    out[i].ID,
+			Count:  1,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(pending) == 1 {
+			out[i].Deliveries = pending[0].RetryCount
+		}
+	}
+	return out, nil
+}
+
+// XClaim re-claims entries already owned by consumer, resetting their idle time.
+// Workers call it periodically so long-running entries are not reclaimed by others.
+func (r *RedisStore) XClaim(ctx context.Context, stream, group, consumer string, ids ...string) error {
+	return r.client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		Messages: ids,
+	}).Err()
+}
@@ -0,0 +1,187 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sink"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/syncer"
+
+	"github.com/robfig/cron/v3"
+)
+
+// TriggerScheduler marks sync runs started by the scheduler.
+const TriggerScheduler = "scheduler"
+
+// tickInterval is how often the scheduler re-reads site configs and starts due syncs.
+const tickInterval = 30 * time.Second
+
+// Scheduler runs each site's sync on the schedule stored in its SiteConfig, never
+// running more than a fixed number of syncs at once or the same site twice.
+type Scheduler struct {
+	sitesMgr        *sites.Manager
+	difyClient      *dify.DifyClient
+	sinks           []sink.Sink
+	defaultSchedule string
+	jitter          time.Duration
+	slots           chan struct{}
+
+	mu      sync.Mutex
+	next    map[string]time.Time // site ID -> next due time
+	specs   map[string]string    // site ID -> schedule the next time was computed from
+	running map[string]bool
+}
+
+// New creates a Scheduler. Sites without their own schedule use defaultSchedule,
+// or are not scheduled at all when it is empty. Each due time is delayed by a random
+// amount up to jitter so sites sharing a schedule do not all start at once.
+func New(sitesMgr *sites.Manager, difyClient *dify.DifyClient, concurrency int, defaultSchedule string, jitter time.Duration, sinks ...sink.Sink) *Scheduler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Scheduler{
+		sitesMgr:        sitesMgr,
+		difyClient:      difyClient,
+		sinks:           sinks,
+		defaultSchedule: defaultSchedule,
+		jitter:          jitter,
+		slots:           make(chan struct{}, concurrency),
+		next:            make(map[string]time.Time),
+		specs:           make(map[string]string),
+		running:         make(map[string]bool),
+	}
+}
+
+// ParseSchedule accepts a standard 5-field cron expression, a descriptor such as
+// "@hourly" or "@every 30m", or a bare Go duration such as "30m".
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("interval must be positive: %s", spec)
+		}
+		return cron.Every(d), nil
+	}
+	return cron.ParseStandard(spec)
+}
+
+// Run starts due syncs until ctx is cancelled, then waits for running syncs to finish.
+func (s *Scheduler) Run(ctx context.Context) {
+	logger.Log.Infof("Scheduler started (concurrency %d)", cap(s.slots))
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	for {
+		s.tick(ctx, &wg)
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			logger.Log.Infof("Scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context, wg *sync.WaitGroup) {
+	allSites, err := s.sitesMgr.ListSites(ctx)
+	if err != nil {
+		logger.Log.Errorf("Scheduler failed to list sites: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, sc := range allSites {
+		if !s.due(sc, now) {
+			continue
+		}
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			// All slots busy; the site stays due and is retried on the next tick.
+			return
+		}
+
+		s.mu.Lock()
+		s.running[sc.SiteID] = true
+		s.mu.Unlock()
+
+		wg.Add(1)
+		go func(siteID string) {
+			defer wg.Done()
+			defer func() { <-s.slots }()
+			s.runSite(ctx, siteID)
+		}(sc.SiteID)
+	}
+}
+
+// due reports whether a site should start syncing now, computing its next due time on
+// first sight or after its schedule changed.
+func (s *Scheduler) due(sc *sites.SiteConfig, now time.Time) bool {
+	spec := sc.SyncSchedule
+	if spec == "" {
+		spec = s.defaultSchedule
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if spec == "" || sc.SyncPaused || !sc.Active() || sc.Source() == sites.SourceWXR {
+		delete(s.next, sc.SiteID)
+		delete(s.specs, sc.SiteID)
+		return false
+	}
+	if s.running[sc.SiteID] {
+		return false
+	}
+	if s.specs[sc.SiteID] != spec {
+		sched, err := ParseSchedule(spec)
+		if err != nil {
+			logger.Log.Errorf("Invalid sync schedule %q for site %s: %v", spec, sc.SiteID, err)
+			delete(s.next, sc.SiteID)
+			return false
+		}
+		s.specs[sc.SiteID] = spec
+		s.next[sc.SiteID] = s.withJitter(sched.Next(now))
+		logger.Log.Infof("Site %s scheduled (%s), next sync at %s", sc.SiteID, spec, s.next[sc.SiteID])
+	}
+	return !now.Before(s.next[sc.SiteID])
+}
+
+func (s *Scheduler) runSite(ctx context.Context, siteID string) {
+	logger.Log.Infof("Scheduler starting sync of site %s", siteID)
+	err := syncer.SyncRegisteredSite(ctx, s.sitesMgr, s.difyClient, siteID, syncer.RunOptions{
+		Trigger: TriggerScheduler,
+		Sinks:   s.sinks,
+	})
+	if errors.Is(err, sites.ErrSyncInProgress) {
+		logger.Log.Infof("Skipping scheduled sync of site %s: %v", siteID, err)
+	} else if err != nil {
+		logger.Log.Errorf("Scheduled sync of site %s failed: %v", siteID, err)
+	} else {
+		logger.Log.Infof("Scheduled sync of site %s finished", siteID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, siteID)
+	if sched, err := ParseSchedule(s.specs[siteID]); err == nil {
+		s.next[siteID] = s.withJitter(sched.Next(time.Now()))
+	}
+}
+
+func (s *Scheduler) withJitter(t time.Time) time.Time {
+	if s.jitter <= 0 {
+		return t
+	}
+	return t.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+}
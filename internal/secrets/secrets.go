@@ -0,0 +1,149 @@
+// Package secrets encrypts credentials such as WordPress access tokens before they
+// are stored, using AES-GCM with named keys so keys can be rotated.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prefix marks encrypted values: "enc:<key id>:<base64 nonce+ciphertext>".
+const prefix = "enc:"
+
+// ErrNoKeys is returned when an encrypted value is read without any keys configured.
+var ErrNoKeys = errors.New("value is encrypted but no encryption keys are configured")
+
+// Keyring holds the keys values can be decrypted with. New values are always
+// encrypted with the primary key.
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// ParseKeys builds a keyring from entries of the form "<key id>:<base64 key>", separated
+// by commas or newlines. Keys must be 16, 24 or 32 bytes; the first entry is the primary key.
+// Blank lines and lines starting with '#' are ignored. An empty spec yields a nil keyring.
+func ParseKeys(spec string) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid key entry %q, expected <key id>:<base64 key>", entry)
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("key %s: invalid base64: %w", id, err)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		if _, dup := k.aeads[id]; dup {
+			return nil, fmt.Errorf("duplicate key id %s", id)
+		}
+		k.aeads[id] = aead
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+	if k.primary == "" {
+		return nil, nil
+	}
+	return k, nil
+}
+
+// LoadKeys builds a keyring from the keys in spec followed by those in the file at
+// path, if set. It returns nil when neither provides a key.
+func LoadKeys(spec, path string) (*Keyring, error) {
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		if spec != "" {
+			spec += ","
+		}
+		spec += string(b)
+	}
+	return ParseKeys(spec)
+}
+
+// PrimaryKeyID returns the ID of the key new values are encrypted with, or "" for a nil keyring.
+func (k *Keyring) PrimaryKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
+
+// IsEncrypted reports whether value was produced by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyID returns the ID of the key value was encrypted with, or "" if it is plaintext.
+func KeyID(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	return id
+}
+
+// Encrypt encrypts plaintext with the primary key. context is authenticated but not
+// stored, binding the ciphertext to e.g. the site it belongs to. Empty values stay
+// empty, and a nil keyring returns plaintext unchanged.
+func (k *Keyring) Encrypt(plaintext, context string) (string, error) {
+	if plaintext == "" || k == nil {
+		return plaintext, nil
+	}
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return prefix + k.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt. Plaintext values written before encryption was enabled
+// are returned unchanged. A nil keyring can only read plaintext values.
+func (k *Keyring) Decrypt(value, context string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if k == nil {
+		return "", ErrNoKeys
+	}
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return "", errors.New("malformed encrypted value")
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return "", fmt.Errorf("value is encrypted with unknown key %q", id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(context))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value with key %q: %w", id, err)
+	}
+	return string(plaintext), nil
+}
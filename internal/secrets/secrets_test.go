@@ -0,0 +1,110 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func mustParseKeys(t *testing.T, spec string) *Keyring {
+	t.Helper()
+	k, err := ParseKeys(spec)
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+	return k
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	k := mustParseKeys(t, "k1:"+testKey('a'))
+
+	enc, err := k.Encrypt("secret-token", "site-1")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !IsEncrypted(enc) || KeyID(enc) != "k1" || strings.Contains(enc, "secret-token") {
+		t.Fatalf("Encrypt = %q, want an enc:k1: value hiding the plaintext", enc)
+	}
+	again, _ := k.Encrypt("secret-token", "site-1")
+	if again == enc {
+		t.Error("encrypting the same value twice gave the same ciphertext")
+	}
+
+	got, err := k.Decrypt(enc, "site-1")
+	if err != nil || got != "secret-token" {
+		t.Fatalf("Decrypt = %q, %v; want the plaintext", got, err)
+	}
+	if _, err := k.Decrypt(enc, "site-2"); err == nil {
+		t.Error("Decrypt with another context succeeded")
+	}
+}
+
+func TestEmptyAndPlaintextValues(t *testing.T) {
+	k := mustParseKeys(t, "k1:"+testKey('a'))
+	if enc, err := k.Encrypt("", "site-1"); err != nil || enc != "" {
+		t.Errorf("Encrypt of an empty value = %q, %v; want it left empty", enc, err)
+	}
+	if got, err := k.Decrypt("legacy-plaintext", "site-1"); err != nil || got != "legacy-plaintext" {
+		t.Errorf("Decrypt of plaintext = %q, %v; want it unchanged", got, err)
+	}
+
+	var none *Keyring
+	if enc, err := none.Encrypt("token", "site-1"); err != nil || enc != "token" {
+		t.Errorf("nil keyring Encrypt = %q, %v; want plaintext", enc, err)
+	}
+	enc, _ := k.Encrypt("token", "site-1")
+	if _, err := none.Decrypt(enc, "site-1"); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("nil keyring Decrypt error = %v, want ErrNoKeys", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := mustParseKeys(t, "k1:"+testKey('a'))
+	enc, err := old.Encrypt("token", "site-1")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	// A new primary key is listed first; the old one stays for decryption.
+	rotated := mustParseKeys(t, "k2:"+testKey('b')+"\n# retired\nk1:"+testKey('a'))
+	if rotated.PrimaryKeyID() != "k2" {
+		t.Fatalf("PrimaryKeyID = %q, want k2", rotated.PrimaryKeyID())
+	}
+	if got, err := rotated.Decrypt(enc, "site-1"); err != nil || got != "token" {
+		t.Fatalf("Decrypt of an old value = %q, %v; want the plaintext", got, err)
+	}
+	reenc, err := rotated.Encrypt("token", "site-1")
+	if err != nil || KeyID(reenc) != "k2" {
+		t.Fatalf("Encrypt after rotation = %q, %v; want a k2 value", reenc, err)
+	}
+
+	// Once the old key is dropped, only values re-encrypted with the new one can be read.
+	newOnly := mustParseKeys(t, "k2:"+testKey('b'))
+	if _, err := newOnly.Decrypt(enc, "site-1"); err == nil {
+		t.Error("Decrypt of a k1 value succeeded without k1")
+	}
+	if got, err := newOnly.Decrypt(reenc, "site-1"); err != nil || got != "token" {
+		t.Errorf("Decrypt of a re-encrypted value = %q, %v; want the plaintext", got, err)
+	}
+}
+
+func TestParseKeysRejectsInvalidEntries(t *testing.T) {
+	for _, spec := range []string{
+		"no-separator",
+		"k1:not base64!",
+		"k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"k1:" + testKey('a') + ",k1:" + testKey('b'),
+	} {
+		if _, err := ParseKeys(spec); err == nil {
+			t.Errorf("ParseKeys(%q) succeeded", spec)
+		}
+	}
+	if k, err := ParseKeys(" \n# only a comment\n"); err != nil || k != nil {
+		t.Errorf("ParseKeys of no keys = %v, %v; want nil, nil", k, err)
+	}
+}
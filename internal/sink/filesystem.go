@@ -0,0 +1,179 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dify-wp-sync/internal/source"
+)
+
+// Filesystem writes one markdown file with YAML front matter per post under
+// <dir>/<site>/, and keeps <dir>/<site>/manifest.jsonl listing those files, one
+// record per post ordered by post ID. A site's manifest is read on its first write
+// and kept in memory until Flush writes it back.
+type Filesystem struct {
+	dir string
+	mu  sync.Mutex
+	// manifests holds the records of the sites written since their last Flush, by
+	// site directory.
+	manifests map[string]map[int]ManifestRecord
+}
+
+// ManifestRecord is one line of a site's manifest.jsonl.
+type ManifestRecord struct {
+	SiteID   string    `json:"site_id"`
+	PostID   int       `json:"post_id"`
+	File     string    `json:"file,omitempty"`
+	Title    string    `json:"title,omitempty"`
+	URL      string    `json:"url,omitempty"`
+	Type     string    `json:"type,omitempty"`
+	Modified time.Time `json:"modified,omitempty"`
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// NewFilesystem returns a sink rooted at dir, which is created on first write.
+func NewFilesystem(dir string) *Filesystem {
+	return &Filesystem{dir: dir, manifests: make(map[string]map[int]ManifestRecord)}
+}
+
+// siteDir turns a site ID such as "example.com/blog" into a safe directory name.
+func (f *Filesystem) siteDir(siteID string) string {
+	return filepath.Join(f.dir, strings.NewReplacer("/", "_", ":", "_", "\\", "_").Replace(siteID))
+}
+
+func (f *Filesystem) Put(ctx context.Context, siteID string, it source.Item, markdown string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dir := f.siteDir(siteID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.md", it.ID)
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	writeField(&b, "site_id", siteID)
+	fmt.Fprintf(&b, "post_id: %d\n", it.ID)
+	writeField(&b, "title", it.Title)
+	writeField(&b, "url", it.URL)
+	writeField(&b, "type", it.Type)
+	writeField(&b, "modified", it.Modified.UTC().Format(time.RFC3339))
+	b.WriteString("---\n\n")
+	b.WriteString(markdown)
+	b.WriteString("\n")
+
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	records, err := f.manifest(dir)
+	if err != nil {
+		return err
+	}
+	records[it.ID] = ManifestRecord{
+		SiteID:   siteID,
+		PostID:   it.ID,
+		File:     name,
+		Title:    it.Title,
+		URL:      it.URL,
+		Type:     it.Type,
+		Modified: it.Modified,
+		SyncedAt: time.Now().UTC(),
+	}
+	return nil
+}
+
+func (f *Filesystem) Delete(ctx context.Context, siteID string, postID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dir := f.siteDir(siteID)
+	err := os.Remove(filepath.Join(dir, fmt.Sprintf("%d.md", postID)))
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return nil
+		}
+	} else if err != nil {
+		return err
+	}
+	records, err := f.manifest(dir)
+	if err != nil {
+		return err
+	}
+	delete(records, postID)
+	return nil
+}
+
+// Flush writes the site's manifest if it changed since the last Flush and forgets it,
+// so the next run reads it afresh. The manifest is rewritten through a temporary file,
+// so re-runs never leave duplicate records and readers never see a partial one.
+func (f *Filesystem) Flush(ctx context.Context, siteID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dir := f.siteDir(siteID)
+	records, ok := f.manifests[dir]
+	if !ok {
+		return nil
+	}
+	postIDs := make([]int, 0, len(records))
+	for id := range records {
+		postIDs = append(postIDs, id)
+	}
+	sort.Ints(postIDs)
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, id := range postIDs {
+		if err := enc.Encode(records[id]); err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(dir, "manifest.jsonl")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	delete(f.manifests, dir)
+	return nil
+}
+
+// manifest returns the in-memory manifest records of dir, reading them from its
+// manifest.jsonl on first use. The caller must hold f.mu.
+func (f *Filesystem) manifest(dir string) (map[int]ManifestRecord, error) {
+	if records, ok := f.manifests[dir]; ok {
+		return records, nil
+	}
+	records := make(map[int]ManifestRecord)
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.jsonl"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var r ManifestRecord
+		if strings.TrimSpace(line) == "" || json.Unmarshal([]byte(line), &r) != nil {
+			continue
+		}
+		records[r.PostID] = r
+	}
+	f.manifests[dir] = records
+	return records, nil
+}
+
+// writeField writes a YAML key with a double-quoted value. JSON string escaping
+// is valid YAML, so titles with colons or quotes round-trip safely.
+func writeField(b *strings.Builder, key, value string) {
+	q, _ := json.Marshal(value)
+	fmt.Fprintf(b, "%s: %s\n", key, q)
+}
@@ -0,0 +1,19 @@
+package sink
+
+import (
+	"context"
+
+	"dify-wp-sync/internal/source"
+)
+
+// Sink receives every document the sync sends to Dify, so content can be mirrored
+// elsewhere (audit snapshots, other RAG systems).
+type Sink interface {
+	// Put stores the markdown rendering of an item, replacing any previous version.
+	Put(ctx context.Context, siteID string, it source.Item, markdown string) error
+	// Delete removes a previously stored item.
+	Delete(ctx context.Context, siteID string, postID int) error
+	// Flush persists whatever the sink buffered for the site. The sync calls it once
+	// it is done with the site rather than after every item.
+	Flush(ctx context.Context, siteID string) error
+}
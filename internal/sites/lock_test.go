@@ -0,0 +1,100 @@
+package sites
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"dify-wp-sync/internal/localstore"
+	"dify-wp-sync/internal/storage"
+)
+
+func TestStaleLockHolderCannotOverwriteConfig(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(localstore.NewMemory(), nil)
+	if err := m.AddSite(ctx, &SiteConfig{SiteID: "42", BlogURL: "https://old.example.com"}); err != nil {
+		t.Fatalf("AddSite: %v", err)
+	}
+
+	stale, err := m.LockSite(ctx, "42", "stale", 0)
+	if err != nil {
+		t.Fatalf("LockSite: %v", err)
+	}
+	defer stale.Unlock()
+	staleCfg, err := m.GetSite(ctx, "42")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+
+	// The lease runs out behind the holder's back and another process takes the lock.
+	if err := stale.lease.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if _, err := m.ModifySite(ctx, "42", "newer", 0, func(sc *SiteConfig) error {
+		sc.BlogURL = "https://new.example.com"
+		return nil
+	}); err != nil {
+		t.Fatalf("ModifySite: %v", err)
+	}
+
+	staleCfg.BlogURL = "https://stale.example.com"
+	if err := m.UpdateSiteLocked(ctx, staleCfg, stale); !errors.Is(err, storage.ErrFenced) {
+		t.Fatalf("UpdateSiteLocked by the stale holder = %v, want ErrFenced", err)
+	}
+	sc, err := m.GetSite(ctx, "42")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	if sc.BlogURL != "https://new.example.com" {
+		t.Errorf("BlogURL = %q, want the newer holder's change", sc.BlogURL)
+	}
+}
+
+func TestLockSiteRefusesHeldLock(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(localstore.NewMemory(), nil)
+	held, err := m.LockSite(ctx, "42", "first", 0)
+	if err != nil {
+		t.Fatalf("LockSite: %v", err)
+	}
+	if _, err := m.LockSite(ctx, "42", "second", 0); !errors.Is(err, ErrSyncInProgress) {
+		t.Fatalf("second LockSite = %v, want ErrSyncInProgress", err)
+	}
+	held.Unlock()
+	again, err := m.LockSite(ctx, "42", "second", 0)
+	if err != nil {
+		t.Fatalf("LockSite after Unlock: %v", err)
+	}
+	again.Unlock()
+}
+
+func TestLostLockCancelsItsContext(t *testing.T) {
+	defer func(d time.Duration) { renewInterval = d }(renewInterval)
+	renewInterval = 10 * time.Millisecond
+
+	ctx := context.Background()
+	m := NewManager(localstore.NewMemory(), nil)
+	lock, err := m.LockSite(ctx, "42", "holder", 0)
+	if err != nil {
+		t.Fatalf("LockSite: %v", err)
+	}
+	defer lock.Unlock()
+
+	time.Sleep(3 * renewInterval)
+	if err := lock.Context().Err(); err != nil {
+		t.Fatalf("context of a renewed lock is done: %v", err)
+	}
+
+	if err := lock.lease.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	select {
+	case <-lock.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context was not canceled after the lock was lost")
+	}
+	if cause := context.Cause(lock.Context()); !errors.Is(cause, storage.ErrLeaseLost) {
+		t.Errorf("cancellation cause = %v, want ErrLeaseLost", cause)
+	}
+}
@@ -4,22 +4,34 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"dify-wp-sync/internal/logger"
-	"dify-wp-sync/internal/redisstore"
+	"dify-wp-sync/internal/secrets"
+	"dify-wp-sync/internal/storage"
 )
 
 const (
 	sitesSetKey = "wp_sites" // A Redis set containing site IDs
 )
 
+// ErrSiteNotFound is returned when no site is registered under the requested ID.
+var ErrSiteNotFound = errors.New("site not found")
+
 type Manager struct {
-	store *redisstore.RedisStore
+	store storage.Store
+	keys  *secrets.Keyring // nil stores access tokens in plaintext
+
+	// WPComAPIBase is the WordPress.com REST API root that syncs of WordPress.com
+	// sites read from; empty uses the public API.
+	WPComAPIBase string
 }
 
-func NewManager(store *redisstore.RedisStore) *Manager {
-	return &Manager{store: store}
+func NewManager(store storage.Store, keys *secrets.Keyring) *Manager {
+	return &Manager{store: store, keys: keys}
 }
 
 func (m *Manager) siteKey(siteID string) string {
@@ -30,27 +42,57 @@ func (m *Manager) AddSite(ctx context.Context, cfg *SiteConfig) error {
 	if cfg.PostDocMapping == nil {
 		cfg.PostDocMapping = make(map[int]string)
 	}
-	err := m.store.SetJSON(ctx, m.siteKey(cfg.SiteID), cfg, 0)
+	cfg.SchemaVersion = CurrentSchemaVersion
+	stored, err := m.sealed(cfg)
 	if err != nil {
 		return err
 	}
+	err = m.store.SetJSONVersioned(ctx, m.siteKey(cfg.SiteID), CurrentSchemaVersion, stored)
+	if err != nil {
+		return newerSchemaError(cfg.SiteID, err)
+	}
+	for postID, docID := range cfg.PostDocMapping {
+		if err := m.UpdatePostDocMapping(ctx, cfg.SiteID, postID, docID); err != nil {
+			return err
+		}
+	}
 	return m.store.SAdd(ctx, sitesSetKey, cfg.SiteID)
 }
 
 func (m *Manager) GetSite(ctx context.Context, siteID string) (*SiteConfig, error) {
-	var sc SiteConfig
-	found, err := m.store.GetJSON(ctx, m.siteKey(siteID), &sc)
+	sc, err := m.loadSite(ctx, siteID)
 	if err != nil {
 		return nil, err
 	}
-	if !found {
-		return nil, errors.New("site not found")
+	sc.PostDocMapping, err = m.GetPostDocMapping(ctx, siteID)
+	if err != nil {
+		return nil, err
 	}
-	return &sc, nil
+	return sc, nil
 }
 
-func (m *Manager) UpdateSite(ctx context.Context, cfg *SiteConfig) error {
-	return m.store.SetJSON(ctx, m.siteKey(cfg.SiteID), cfg, 0)
+// DeleteSite removes the site from the site list and deletes its config, post mapping,
+// sync history, recovery log and webhook post versions, and its shared token if no
+// other site uses it. The caller must hold the site's lock; the lock's fencing counter is kept
+// so that holders of older locks still cannot write the config back.
+func (m *Manager) DeleteSite(ctx context.Context, lock *SiteLock) error {
+	siteID := lock.SiteID
+	var stored struct {
+		TokenID string `json:"token_id"`
+	}
+	if _, err := m.store.GetJSON(ctx, m.siteKey(siteID), &stored); err != nil {
+		return err
+	}
+	if err := m.store.SRem(ctx, sitesSetKey, siteID); err != nil {
+		return err
+	}
+	if err := m.store.Del(ctx, m.siteKey(siteID), m.mappingKey(siteID), m.syncRunsKey(siteID), m.recoveriesKey(siteID), m.postVersionsKey(siteID)); err != nil {
+		return err
+	}
+	if stored.TokenID != "" {
+		return m.DeleteUnusedSharedToken(ctx, stored.TokenID)
+	}
+	return nil
 }
 
 func (m *Manager) ListSites(ctx context.Context) ([]*SiteConfig, error) {
@@ -70,23 +112,112 @@ func (m *Manager) ListSites(ctx context.Context) ([]*SiteConfig, error) {
 	return sites, nil
 }
 
-func (m *Manager) UpdateLastSyncTime(ctx context.Context, siteID string, t time.Time) error {
-	sc, err := m.GetSite(ctx, siteID)
+// FindSiteByBlogURL returns the registered site whose blog URL matches blogURL,
+// ignoring the scheme, a leading "www." and a trailing slash, or ErrSiteNotFound.
+func (m *Manager) FindSiteByBlogURL(ctx context.Context, blogURL string) (*SiteConfig, error) {
+	want := normalizeBlogURL(blogURL)
+	all, err := m.ListSites(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if t.After(sc.LastSyncTime) {
-		sc.LastSyncTime = t
-		return m.UpdateSite(ctx, sc)
+	for _, sc := range all {
+		if want != "" && normalizeBlogURL(sc.BlogURL) == want {
+			return sc, nil
+		}
 	}
-	return nil
+	return nil, fmt.Errorf("no site with blog URL %s: %w", blogURL, ErrSiteNotFound)
 }
 
-func (m *Manager) UpdatePostDocMapping(ctx context.Context, siteID string, postID int, docID string) error {
-	sc, err := m.GetSite(ctx, siteID)
+func normalizeBlogURL(blogURL string) string {
+	u, err := url.Parse(strings.TrimSpace(blogURL))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	return host + strings.TrimRight(u.Path, "/")
+}
+
+// DatasetUsers returns the IDs of every site that syncs into datasetID. Unlike
+// ListSites it reads only the dataset ID of each stored record and fails if any
+// record cannot be read, so a site that does not load is never taken for one that
+// does not use the dataset.
+func (m *Manager) DatasetUsers(ctx context.Context, datasetID string) ([]string, error) {
+	ids, err := m.store.SMembers(ctx, sitesSetKey)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var users []string
+	for _, id := range ids {
+		var stored struct {
+			DifyDatasetID string `json:"dify_dataset_id"`
+		}
+		if _, err := m.store.GetJSON(ctx, m.siteKey(id), &stored); err != nil {
+			return nil, fmt.Errorf("site %s: %w", id, err)
+		}
+		if stored.DifyDatasetID == datasetID {
+			users = append(users, id)
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// UpdateLastSyncTime moves the site's last sync time forward to t under the site's lock.
+func (m *Manager) UpdateLastSyncTime(ctx context.Context, siteID string, t time.Time, wait time.Duration) error {
+	_, err := m.ModifySite(ctx, siteID, "last-sync-time", wait, func(sc *SiteConfig) error {
+		if t.After(sc.LastSyncTime) {
+			sc.LastSyncTime = t
+		}
+		return nil
+	})
+	return err
+}
+
+// CopyTo copies every site's config, shared token, post mapping, sync history and
+// recovery log into dst, replacing whatever dst held for those sites, and returns how
+// many sites were copied. Locks, jobs and webhook bookkeeping are transient and not copied.
+func (m *Manager) CopyTo(ctx context.Context, dst *Manager) (int, error) {
+	ids, err := m.store.SMembers(ctx, sitesSetKey)
+	if err != nil {
+		return 0, err
+	}
+	copied := 0
+	for _, id := range ids {
+		sc, err := m.GetSite(ctx, id)
+		if err != nil {
+			return copied, fmt.Errorf("site %s: %w", id, err)
+		}
+		runs, err := m.ListSyncRuns(ctx, id, maxSyncRuns)
+		if err != nil {
+			return copied, fmt.Errorf("site %s: %w", id, err)
+		}
+		recoveries, err := m.ListRecoveries(ctx, id, maxRecoveries)
+		if err != nil {
+			return copied, fmt.Errorf("site %s: %w", id, err)
+		}
+
+		if err := dst.store.Del(ctx, dst.mappingKey(id), dst.syncRunsKey(id), dst.recoveriesKey(id)); err != nil {
+			return copied, fmt.Errorf("site %s: %w", id, err)
+		}
+		if sc.TokenID != "" {
+			if err := dst.SaveSharedToken(ctx, sc.TokenID, sc.AccessToken, 0); err != nil {
+				return copied, fmt.Errorf("site %s: %w", id, err)
+			}
+		}
+		if err := dst.AddSite(ctx, sc); err != nil {
+			return copied, fmt.Errorf("site %s: %w", id, err)
+		}
+		for i := len(runs) - 1; i >= 0; i-- {
+			if err := dst.RecordSyncRun(ctx, runs[i]); err != nil {
+				return copied, fmt.Errorf("site %s: %w", id, err)
+			}
+		}
+		for i := len(recoveries) - 1; i >= 0; i-- {
+			if err := dst.RecordRecovery(ctx, recoveries[i]); err != nil {
+				return copied, fmt.Errorf("site %s: %w", id, err)
+			}
+		}
+		copied++
 	}
-	sc.PostDocMapping[postID] = docID
-	return m.UpdateSite(ctx, sc)
+	return copied, nil
 }
@@ -0,0 +1,96 @@
+package sites
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"dify-wp-sync/internal/logger"
+)
+
+// Post-to-document mappings live in a Redis hash per site (field: post ID, value: doc ID)
+// rather than inside the site's JSON blob, so each change is a single atomic HSET/HDEL
+// and the blob stays small however many posts a site has.
+
+func (m *Manager) mappingKey(siteID string) string {
+	return fmt.Sprintf("wp_site_docs:%s", siteID)
+}
+
+// SetDocID records the Dify document for a post in the site's mapping hash and in
+// sc.PostDocMapping.
+func (m *Manager) SetDocID(ctx context.Context, sc *SiteConfig, postID int, docID string) error {
+	if err := m.UpdatePostDocMapping(ctx, sc.SiteID, postID, docID); err != nil {
+		return err
+	}
+	if sc.PostDocMapping == nil {
+		sc.PostDocMapping = make(map[int]string)
+	}
+	sc.PostDocMapping[postID] = docID
+	return nil
+}
+
+// RemoveDocID forgets the document for a post.
+func (m *Manager) RemoveDocID(ctx context.Context, sc *SiteConfig, postID int) error {
+	if err := m.DeletePostDocMapping(ctx, sc.SiteID, postID); err != nil {
+		return err
+	}
+	delete(sc.PostDocMapping, postID)
+	return nil
+}
+
+// ClearDocIDs forgets every document mapping of the site.
+func (m *Manager) ClearDocIDs(ctx context.Context, sc *SiteConfig) error {
+	if err := m.store.Del(ctx, m.mappingKey(sc.SiteID)); err != nil {
+		return err
+	}
+	sc.PostDocMapping = make(map[int]string)
+	return nil
+}
+
+// ReplaceDocIDs replaces the site's whole mapping with mapping in one atomic write, so
+// a failure leaves the previous mapping in place rather than part of the new one.
+func (m *Manager) ReplaceDocIDs(ctx context.Context, sc *SiteConfig, mapping map[int]string) error {
+	fields := make(map[string]interface{}, len(mapping))
+	for postID, docID := range mapping {
+		fields[strconv.Itoa(postID)] = docID
+	}
+	if err := m.store.HReplaceJSON(ctx, m.mappingKey(sc.SiteID), fields); err != nil {
+		return err
+	}
+	sc.PostDocMapping = make(map[int]string, len(mapping))
+	for postID, docID := range mapping {
+		sc.PostDocMapping[postID] = docID
+	}
+	return nil
+}
+
+func (m *Manager) UpdatePostDocMapping(ctx context.Context, siteID string, postID int, docID string) error {
+	return m.store.HSetJSON(ctx, m.mappingKey(siteID), strconv.Itoa(postID), docID)
+}
+
+func (m *Manager) DeletePostDocMapping(ctx context.Context, siteID string, postID int) error {
+	return m.store.HDel(ctx, m.mappingKey(siteID), strconv.Itoa(postID))
+}
+
+// GetPostDocMapping loads the site's full post-to-document mapping.
+func (m *Manager) GetPostDocMapping(ctx context.Context, siteID string) (map[int]string, error) {
+	raw, err := m.store.HGetAll(ctx, m.mappingKey(siteID))
+	if err != nil {
+		return nil, err
+	}
+	mapping := make(map[int]string, len(raw))
+	for field, value := range raw {
+		postID, err := strconv.Atoi(field)
+		if err != nil {
+			logger.Log.Warnf("Ignoring invalid post ID %q in mapping of site %s", field, siteID)
+			continue
+		}
+		var docID string
+		if err := json.Unmarshal([]byte(value), &docID); err != nil {
+			return nil, fmt.Errorf("invalid mapping for post %d of site %s: %w", postID, siteID, err)
+		}
+		mapping[postID] = docID
+	}
+	return mapping, nil
+}
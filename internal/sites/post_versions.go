@@ -0,0 +1,30 @@
+package sites
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Webhook notifications can arrive late or out of order. The modification time of the
+// last notified change applied to each post is kept in a hash per site, so an older
+// notification never undoes a newer one.
+
+func (m *Manager) postVersionsKey(siteID string) string {
+	return fmt.Sprintf("wp_webhook_versions:%s", siteID)
+}
+
+// PostVersion returns the modification time of the last notified change applied to a
+// post, or the zero time if there was none.
+func (m *Manager) PostVersion(ctx context.Context, siteID string, postID int) (time.Time, error) {
+	var modified time.Time
+	_, err := m.store.HGetJSON(ctx, m.postVersionsKey(siteID), strconv.Itoa(postID), &modified)
+	return modified, err
+}
+
+// SetPostVersion records that a notified change was applied to a post. The caller must
+// hold the site's lock and have checked PostVersion under it.
+func (m *Manager) SetPostVersion(ctx context.Context, siteID string, postID int, modified time.Time) error {
+	return m.store.HSetJSON(ctx, m.postVersionsKey(siteID), strconv.Itoa(postID), modified)
+}
@@ -0,0 +1,58 @@
+package sites
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// maxRecoveries is how many recovery events are kept per site.
+const maxRecoveries = 50
+
+// Recovery kinds.
+const (
+	RecoveryDocumentRecreated = "document_recreated" // The mapped document was gone and was created again
+	RecoveryDatasetRecreated  = "dataset_recreated"  // The site's dataset was gone; a new one replaced it and the mapping was cleared
+)
+
+// Recovery records a sync repairing state that was changed behind its back in Dify.
+type Recovery struct {
+	SiteID string    `json:"site_id"`
+	At     time.Time `json:"at"`
+	Kind   string    `json:"kind"`
+	PostID int       `json:"post_id,omitempty"` // Only for documents
+	OldID  string    `json:"old_id"`            // Missing document or dataset
+	NewID  string    `json:"new_id"`            // Its replacement
+}
+
+func (m *Manager) recoveriesKey(siteID string) string {
+	return fmt.Sprintf("wp_site_recoveries:%s", siteID)
+}
+
+// RecordRecovery prepends an event to the site's recovery log, keeping the most recent
+// maxRecoveries.
+func (m *Manager) RecordRecovery(ctx context.Context, r *Recovery) error {
+	key := m.recoveriesKey(r.SiteID)
+	if err := m.store.LPushJSON(ctx, key, r); err != nil {
+		return err
+	}
+	return m.store.LTrim(ctx, key, 0, maxRecoveries-1)
+}
+
+// ListRecoveries returns up to limit of the site's most recent recovery events, newest first.
+func (m *Manager) ListRecoveries(ctx context.Context, siteID string, limit int) ([]*Recovery, error) {
+	raw, err := m.store.LRange(ctx, m.recoveriesKey(siteID), 0, int64(limit)-1)
+	if err != nil {
+		return nil, err
+	}
+	recoveries := make([]*Recovery, 0, len(raw))
+	for _, r := range raw {
+		var rec Recovery
+		if err := json.Unmarshal([]byte(r), &rec); err != nil {
+			return nil, err
+		}
+		recoveries = append(recoveries, &rec)
+	}
+	return recoveries, nil
+}
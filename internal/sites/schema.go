@@ -0,0 +1,238 @@
+package sites
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/storage"
+)
+
+// CurrentSchemaVersion is the version of the site records this binary reads and writes.
+// Bump it together with a new entry in migrations whenever the stored shape changes.
+const CurrentSchemaVersion = 5
+
+// ErrNewerSchema is returned when a site record was written by a newer version of
+// this tool. Such records are neither read nor overwritten.
+var ErrNewerSchema = errors.New("site record was written by a newer version; upgrade this binary")
+
+// record is a stored site config as raw JSON fields, so migrations can see
+// fields SiteConfig no longer has.
+type record map[string]json.RawMessage
+
+// version returns the record's schema version; records from before versioning are 0.
+func (r record) version() (int, error) {
+	raw, ok := r["schema_version"]
+	if !ok {
+		return 0, nil
+	}
+	var v int
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return 0, fmt.Errorf("invalid schema_version: %w", err)
+	}
+	return v, nil
+}
+
+// isEmpty reports whether field is missing, null or a zero value.
+func (r record) isEmpty(field string) bool {
+	switch string(r[field]) {
+	case "", "null", `""`, "[]", "{}":
+		return true
+	}
+	return false
+}
+
+// migration upgrades a record of one schema version to the next in place.
+type migration func(ctx context.Context, m *Manager, siteID string, rec record) error
+
+// migrations[i] upgrades a record from version i to version i+1.
+var migrations = []migration{
+	migrateDefaults,
+	migrateMappingToHash,
+	migrateEncryptedTokens,
+	migrateSharedTokens,
+	migrateEncryptedWebhookSecrets,
+}
+
+// migrateDefaults (0 -> 1) makes the defaults older records relied on explicit:
+// WordPress.com as the source, "post" as the only post type and an active status.
+func migrateDefaults(ctx context.Context, m *Manager, siteID string, rec record) error {
+	if rec.isEmpty("source_type") {
+		rec["source_type"] = json.RawMessage(`"` + SourceWPCom + `"`)
+	}
+	if rec.isEmpty("post_types") {
+		rec["post_types"] = json.RawMessage(`["post"]`)
+	}
+	if rec.isEmpty("status") {
+		rec["status"] = json.RawMessage(`"` + StatusActive + `"`)
+	}
+	return nil
+}
+
+// migrateMappingToHash (1 -> 2) moves the post-to-document mapping that used to be
+// embedded in the record into the site's mapping hash. Entries already in the hash win,
+// so re-running it after an interrupted migration is harmless.
+func migrateMappingToHash(ctx context.Context, m *Manager, siteID string, rec record) error {
+	raw, ok := rec["post_doc_mapping"]
+	delete(rec, "post_doc_mapping")
+	if !ok {
+		return nil
+	}
+	var legacy map[int]string
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return fmt.Errorf("invalid post_doc_mapping: %w", err)
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	current, err := m.GetPostDocMapping(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	for postID, docID := range legacy {
+		if _, exists := current[postID]; exists {
+			continue
+		}
+		if err := m.UpdatePostDocMapping(ctx, siteID, postID, docID); err != nil {
+			return err
+		}
+	}
+	logger.Log.Infof("Moved %d post mappings of site %s into %s", len(legacy), siteID, m.mappingKey(siteID))
+	return nil
+}
+
+// migrateEncryptedTokens (2 -> 3) changes nothing in the record itself: version 3
+// marks that access_token may hold an encrypted value, which older binaries would
+// misread as a token. The token is encrypted when the migrated record is next saved
+// and keys are configured.
+func migrateEncryptedTokens(ctx context.Context, m *Manager, siteID string, rec record) error {
+	return nil
+}
+
+// migrateSharedTokens (3 -> 4) changes nothing in the record itself: version 4 marks
+// that a site may refer to a shared token through token_id and store no access_token
+// of its own, which older binaries would take for a site without credentials.
+func migrateSharedTokens(ctx context.Context, m *Manager, siteID string, rec record) error {
+	return nil
+}
+
+// migrateEncryptedWebhookSecrets (4 -> 5) changes nothing in the record itself: version
+// 5 marks that webhook_secret may hold an encrypted value, which older binaries would
+// take for the secret itself and reject every notification with.
+func migrateEncryptedWebhookSecrets(ctx context.Context, m *Manager, siteID string, rec record) error {
+	return nil
+}
+
+// upgrade runs every migration the record still needs.
+func (m *Manager) upgrade(ctx context.Context, siteID string, rec record) error {
+	v, err := rec.version()
+	if err != nil {
+		return err
+	}
+	if v > CurrentSchemaVersion {
+		return fmt.Errorf("site %s has schema version %d, this binary supports %d: %w",
+			siteID, v, CurrentSchemaVersion, ErrNewerSchema)
+	}
+	for ; v < CurrentSchemaVersion; v++ {
+		if err := migrations[v](ctx, m, siteID, rec); err != nil {
+			return fmt.Errorf("migrating site %s from schema version %d: %w", siteID, v, err)
+		}
+		rec["schema_version"] = json.RawMessage(fmt.Sprint(v + 1))
+		logger.Log.Infof("Migrated site %s to schema version %d", siteID, v+1)
+	}
+	return nil
+}
+
+// loadSite reads and, if needed, migrates a site record. The migrated record is not
+// written back here, as the caller may not hold the site's lock; it is stored with
+// the next save of the site, and Migrate saves it under the lock.
+func (m *Manager) loadSite(ctx context.Context, siteID string) (*SiteConfig, error) {
+	var rec record
+	found, err := m.store.GetJSON(ctx, m.siteKey(siteID), &rec)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("site %s: %w", siteID, ErrSiteNotFound)
+	}
+
+	v, err := rec.version()
+	if err != nil {
+		return nil, fmt.Errorf("site %s: %w", siteID, err)
+	}
+	if v != CurrentSchemaVersion {
+		if err := m.upgrade(ctx, siteID, rec); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	var sc SiteConfig
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil, fmt.Errorf("site %s: %w", siteID, err)
+	}
+	if err := m.unseal(ctx, &sc); err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+// newerSchemaError turns a write rejected because of a newer stored record into
+// ErrNewerSchema.
+func newerSchemaError(siteID string, err error) error {
+	if errors.Is(err, storage.ErrNewerVersion) {
+		return fmt.Errorf("refusing to overwrite site %s written by a newer version (this binary writes %d): %w",
+			siteID, CurrentSchemaVersion, ErrNewerSchema)
+	}
+	return err
+}
+
+// Migrate brings every stored site record up to CurrentSchemaVersion under the site's
+// lock, returning how many were migrated. Records are also migrated in memory whenever
+// they are loaded and stored migrated with their next save, so running this is
+// optional but avoids repeating the migrations on every load.
+func (m *Manager) Migrate(ctx context.Context) (int, error) {
+	ids, err := m.store.SMembers(ctx, sitesSetKey)
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, id := range ids {
+		var rec record
+		found, err := m.store.GetJSON(ctx, m.siteKey(id), &rec)
+		if err != nil {
+			return migrated, fmt.Errorf("site %s: %w", id, err)
+		}
+		if !found {
+			continue
+		}
+		v, err := rec.version()
+		if err != nil {
+			return migrated, fmt.Errorf("site %s: %w", id, err)
+		}
+		if v == CurrentSchemaVersion {
+			continue
+		}
+		if v > CurrentSchemaVersion {
+			logger.Log.Warnf("Skipping site %s: schema version %d is newer than %d", id, v, CurrentSchemaVersion)
+			continue
+		}
+		_, err = m.ModifySite(ctx, id, "migrate", 0, func(*SiteConfig) error { return nil })
+		if errors.Is(err, ErrSyncInProgress) {
+			// The running sync saves the migrated record when it finishes.
+			logger.Log.Infof("Skipping site %s: a sync is running and will store it migrated", id)
+			continue
+		}
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
@@ -0,0 +1,104 @@
+package sites
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"dify-wp-sync/internal/localstore"
+)
+
+// putRecord stores rec as the raw record of siteID and registers the site.
+func putRecord(t *testing.T, m *Manager, siteID string, rec map[string]interface{}) {
+	t.Helper()
+	ctx := context.Background()
+	if err := m.store.SetJSON(ctx, m.siteKey(siteID), rec, 0); err != nil {
+		t.Fatalf("SetJSON: %v", err)
+	}
+	if err := m.store.SAdd(ctx, sitesSetKey, siteID); err != nil {
+		t.Fatalf("SAdd: %v", err)
+	}
+}
+
+func storedVersion(t *testing.T, m *Manager, siteID string) int {
+	t.Helper()
+	var stored struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if _, err := m.store.GetJSON(context.Background(), m.siteKey(siteID), &stored); err != nil {
+		t.Fatalf("GetJSON: %v", err)
+	}
+	return stored.SchemaVersion
+}
+
+func TestNewerSchemaIsNeitherReadNorOverwritten(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(localstore.NewMemory(), nil)
+	newer := CurrentSchemaVersion + 1
+	putRecord(t, m, "42", map[string]interface{}{"site_id": "42", "schema_version": newer, "blog_url": "https://example.com"})
+
+	if _, err := m.GetSite(ctx, "42"); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("GetSite = %v, want ErrNewerSchema", err)
+	}
+	if err := m.AddSite(ctx, &SiteConfig{SiteID: "42"}); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("AddSite over the newer record = %v, want ErrNewerSchema", err)
+	}
+	lock, err := m.LockSite(ctx, "42", "test", 0)
+	if err != nil {
+		t.Fatalf("LockSite: %v", err)
+	}
+	err = m.UpdateSiteLocked(ctx, &SiteConfig{SiteID: "42"}, lock)
+	lock.Unlock()
+	if !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("UpdateSiteLocked over the newer record = %v, want ErrNewerSchema", err)
+	}
+	if n, err := m.Migrate(ctx); err != nil || n != 0 {
+		t.Errorf("Migrate = %d, %v; want the newer record skipped", n, err)
+	}
+	if v := storedVersion(t, m, "42"); v != newer {
+		t.Errorf("stored schema version = %d, want the newer record left at %d", v, newer)
+	}
+}
+
+func TestLegacyRecordIsMigrated(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(localstore.NewMemory(), nil)
+	putRecord(t, m, "42", map[string]interface{}{
+		"site_id":          "42",
+		"blog_url":         "https://example.com",
+		"post_doc_mapping": map[string]string{"1": "doc-1", "2": "doc-2"},
+	})
+
+	sc, err := m.GetSite(ctx, "42")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	if sc.Source() != SourceWPCom || !slices.Equal(sc.PostTypes, []string{"post"}) || sc.Status != StatusActive {
+		t.Errorf("migrated site has source %q, post types %v and status %q; want the old defaults", sc.Source(), sc.PostTypes, sc.Status)
+	}
+	if want := map[int]string{1: "doc-1", 2: "doc-2"}; !maps.Equal(sc.PostDocMapping, want) {
+		t.Errorf("PostDocMapping = %v, want %v", sc.PostDocMapping, want)
+	}
+	if v := storedVersion(t, m, "42"); v != 0 {
+		t.Errorf("loading stored schema version %d, want the record left for a locked save", v)
+	}
+
+	if n, err := m.Migrate(ctx); err != nil || n != 1 {
+		t.Fatalf("Migrate = %d, %v; want 1", n, err)
+	}
+	if v := storedVersion(t, m, "42"); v != CurrentSchemaVersion {
+		t.Errorf("stored schema version after Migrate = %d, want %d", v, CurrentSchemaVersion)
+	}
+	var rec map[string]interface{}
+	if _, err := m.store.GetJSON(ctx, m.siteKey("42"), &rec); err != nil {
+		t.Fatalf("GetJSON: %v", err)
+	}
+	if _, ok := rec["post_doc_mapping"]; ok {
+		t.Error("migrated record still embeds the mapping")
+	}
+	if n, err := m.Migrate(ctx); err != nil || n != 0 {
+		t.Errorf("second Migrate = %d, %v; want nothing left to migrate", n, err)
+	}
+}
@@ -0,0 +1,124 @@
+package sites
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/secrets"
+)
+
+// A WordPress.com global token covers every site its user owns. Sites connected with
+// one refer to it by TokenID instead of holding their own copy, so reconnecting the
+// user replaces the token of all of them at once. Shared tokens are encrypted like
+// per-site tokens, authenticated with their token ID.
+
+func (m *Manager) sharedTokenKey(tokenID string) string {
+	return fmt.Sprintf("wp_token:%s", tokenID)
+}
+
+// sharedTokenContext is the additional data shared tokens are encrypted with, kept
+// apart from site IDs so a site's ciphertext cannot be passed off as a shared token.
+func sharedTokenContext(tokenID string) string {
+	return "token:" + tokenID
+}
+
+// SaveSharedToken stores token under tokenID. A non-zero ttl lets a token nobody has
+// picked sites for yet expire; saving it again with 0 keeps it.
+func (m *Manager) SaveSharedToken(ctx context.Context, tokenID, token string, ttl time.Duration) error {
+	sealed, err := m.keys.Encrypt(token, sharedTokenContext(tokenID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt shared token %s: %w", tokenID, err)
+	}
+	return m.store.SetJSON(ctx, m.sharedTokenKey(tokenID), sealed, ttl)
+}
+
+// SharedToken returns the token stored under tokenID.
+func (m *Manager) SharedToken(ctx context.Context, tokenID string) (string, error) {
+	var sealed string
+	found, err := m.store.GetJSON(ctx, m.sharedTokenKey(tokenID), &sealed)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("shared token %s not found", tokenID)
+	}
+	token, err := m.keys.Decrypt(sealed, sharedTokenContext(tokenID))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt shared token %s: %w", tokenID, err)
+	}
+	return token, nil
+}
+
+// storedTokenIDs returns the token ID each site refers to, skipping sites with
+// their own token.
+func (m *Manager) storedTokenIDs(ctx context.Context) (map[string]string, error) {
+	ids, err := m.store.SMembers(ctx, sitesSetKey)
+	if err != nil {
+		return nil, err
+	}
+	tokenIDs := make(map[string]string)
+	for _, id := range ids {
+		var stored struct {
+			TokenID string `json:"token_id"`
+		}
+		if _, err := m.store.GetJSON(ctx, m.siteKey(id), &stored); err != nil {
+			return nil, fmt.Errorf("site %s: %w", id, err)
+		}
+		if stored.TokenID != "" {
+			tokenIDs[id] = stored.TokenID
+		}
+	}
+	return tokenIDs, nil
+}
+
+// DeleteUnusedSharedToken deletes the shared token tokenID once no site refers to it.
+// Call it after a site stops referring to a token, as no other cleanup finds it.
+func (m *Manager) DeleteUnusedSharedToken(ctx context.Context, tokenID string) error {
+	users, err := m.storedTokenIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, used := range users {
+		if used == tokenID {
+			return nil
+		}
+	}
+	return m.store.Del(ctx, m.sharedTokenKey(tokenID))
+}
+
+// reencryptSharedTokens rewrites every shared token in use that is not encrypted
+// with the primary key, returning how many were rewritten.
+func (m *Manager) reencryptSharedTokens(ctx context.Context) (int, error) {
+	users, err := m.storedTokenIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	done := make(map[string]bool)
+	rewritten := 0
+	for _, tokenID := range users {
+		if done[tokenID] {
+			continue
+		}
+		done[tokenID] = true
+
+		var sealed string
+		if _, err := m.store.GetJSON(ctx, m.sharedTokenKey(tokenID), &sealed); err != nil {
+			return rewritten, err
+		}
+		if sealed == "" || secrets.KeyID(sealed) == m.keys.PrimaryKeyID() {
+			continue
+		}
+		token, err := m.SharedToken(ctx, tokenID)
+		if err != nil {
+			return rewritten, err
+		}
+		if err := m.SaveSharedToken(ctx, tokenID, token, 0); err != nil {
+			return rewritten, err
+		}
+		logger.Log.Infof("Re-encrypted shared token %s with key %s", tokenID, m.keys.PrimaryKeyID())
+		rewritten++
+	}
+	return rewritten, nil
+}
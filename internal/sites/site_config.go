@@ -4,13 +4,53 @@ import (
 	"time"
 )
 
+// Source types identify which API a site's content is fetched from.
+const (
+	SourceWPCom      = "wpcom"      // WordPress.com REST API v1.1 with an OAuth token
+	SourceSelfHosted = "selfhosted" // Self-hosted WordPress REST API v2 with an application password
+	SourceWXR        = "wxr"        // Offline WXR export files, only synced through import-wxr
+	SourceFeed       = "feed"       // Public pages listed in a sitemap or RSS/Atom feed, no credentials
+)
+
+// Site statuses. An empty status is treated as active.
+const (
+	StatusActive       = "active"
+	StatusDisconnected = "disconnected" // Credentials were dropped; the dataset and mapping are kept
+	StatusNeedsReauth  = "needs_reauth" // The source rejected the credentials; reconnect to resume syncing
+)
+
 // SiteConfig represents the configuration for a WordPress site.
 type SiteConfig struct {
-	SiteID         string         `json:"site_id"`
-	BlogURL        string         `json:"blog_url"`
-	AccessToken    string         `json:"access_token"`
-	DifyDatasetID  string         `json:"dify_dataset_id"`
-	LastSyncTime   time.Time      `json:"last_sync_time"`
-	PostDocMapping map[int]string `json:"post_doc_mapping"`
-	PostTypes      []string       `json:"post_types"` // New field to specify post types to sync
+	SchemaVersion  int               `json:"schema_version"` // See CurrentSchemaVersion; set on every write
+	SiteID         string            `json:"site_id"`
+	BlogURL        string            `json:"blog_url"`
+	AccessToken    string            `json:"access_token"`       // OAuth token, or the application password for self-hosted sites
+	TokenID        string            `json:"token_id,omitempty"` // Shared global token AccessToken is loaded from; see SaveSharedToken
+	DifyDatasetID  string            `json:"dify_dataset_id"`
+	LastSyncTime   time.Time         `json:"last_sync_time"`
+	LastPruneTime  time.Time         `json:"last_prune_time,omitempty"` // Last full check for deleted items
+	PostDocMapping map[int]string    `json:"-"`                         // Stored in its own hash; change through Manager.SetDocID/RemoveDocID
+	PostTypes      []string          `json:"post_types"`                // New field to specify post types to sync
+	SourceType     string            `json:"source_type,omitempty"`
+	Username       string            `json:"username,omitempty"`   // Only used by self-hosted sites
+	FeedURL        string            `json:"feed_url,omitempty"`   // Sitemap or RSS/Atom URL for feed sites
+	FeedETags      map[string]string `json:"feed_etags,omitempty"` // Last seen ETag per page URL for feed sites
+	WebhookSecret  string            `json:"webhook_secret,omitempty"`
+	SyncSchedule   string            `json:"sync_schedule,omitempty"` // Cron expression or interval ("30m") for the server's scheduler
+	SyncPaused     bool              `json:"sync_paused,omitempty"`   // Skipped by the scheduler while set
+	Status         string            `json:"status,omitempty"`
+}
+
+// Source returns the site's source type, defaulting to WordPress.com for records
+// created before the field existed.
+func (sc *SiteConfig) Source() string {
+	if sc.SourceType == "" {
+		return SourceWPCom
+	}
+	return sc.SourceType
+}
+
+// Active reports whether the site can be synced.
+func (sc *SiteConfig) Active() bool {
+	return sc.Status == "" || sc.Status == StatusActive
 }
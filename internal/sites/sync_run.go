@@ -0,0 +1,58 @@
+package sites
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// maxSyncRuns is how many past runs are kept per site.
+const maxSyncRuns = 50
+
+// Sync run statuses.
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunSkipped   = "skipped" // Another sync of the site held the lock
+)
+
+// SyncRun records the outcome of one sync of a site.
+type SyncRun struct {
+	SiteID     string    `json:"site_id"`
+	Trigger    string    `json:"trigger"` // "cli", "scheduler", ...
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (m *Manager) syncRunsKey(siteID string) string {
+	return fmt.Sprintf("wp_sync_runs:%s", siteID)
+}
+
+// RecordSyncRun prepends a run to the site's history, keeping the most recent maxSyncRuns.
+func (m *Manager) RecordSyncRun(ctx context.Context, run *SyncRun) error {
+	key := m.syncRunsKey(run.SiteID)
+	if err := m.store.LPushJSON(ctx, key, run); err != nil {
+		return err
+	}
+	return m.store.LTrim(ctx, key, 0, maxSyncRuns-1)
+}
+
+// ListSyncRuns returns up to limit of the site's most recent runs, newest first.
+func (m *Manager) ListSyncRuns(ctx context.Context, siteID string, limit int) ([]*SyncRun, error) {
+	raw, err := m.store.LRange(ctx, m.syncRunsKey(siteID), 0, int64(limit)-1)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]*SyncRun, 0, len(raw))
+	for _, r := range raw {
+		var run SyncRun
+		if err := json.Unmarshal([]byte(r), &run); err != nil {
+			return nil, err
+		}
+		runs = append(runs, &run)
+	}
+	return runs, nil
+}
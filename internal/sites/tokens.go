@@ -0,0 +1,130 @@
+package sites
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/secrets"
+)
+
+// Access tokens (and self-hosted application passwords) and webhook secrets are
+// encrypted with the manager's keyring whenever a config is written and decrypted when
+// it is loaded, so SiteConfig always holds the plaintext in memory. The site ID is
+// authenticated along with each value, so a ciphertext copied to another site fails
+// to decrypt; the webhook secret's context also names the field, so it cannot be
+// swapped with the token.
+
+// webhookSecretContext is the context the webhook secret of siteID is encrypted with.
+func webhookSecretContext(siteID string) string {
+	return siteID + ":webhook_secret"
+}
+
+// sealed returns a copy of cfg with its access token and webhook secret encrypted for
+// storage. Sites using a shared token store none of their own.
+func (m *Manager) sealed(cfg *SiteConfig) (*SiteConfig, error) {
+	cp := *cfg
+	secret, err := m.keys.Encrypt(cfg.WebhookSecret, webhookSecretContext(cfg.SiteID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt webhook secret of site %s: %w", cfg.SiteID, err)
+	}
+	cp.WebhookSecret = secret
+	if cfg.TokenID != "" {
+		cp.AccessToken = ""
+		return &cp, nil
+	}
+	token, err := m.keys.Encrypt(cfg.AccessToken, cfg.SiteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt access token of site %s: %w", cfg.SiteID, err)
+	}
+	cp.AccessToken = token
+	return &cp, nil
+}
+
+// unseal decrypts the access token and webhook secret of a config read from storage in
+// place, loading the shared token it refers to if any.
+func (m *Manager) unseal(ctx context.Context, sc *SiteConfig) error {
+	secret, err := m.keys.Decrypt(sc.WebhookSecret, webhookSecretContext(sc.SiteID))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt webhook secret of site %s: %w", sc.SiteID, err)
+	}
+	sc.WebhookSecret = secret
+	if sc.TokenID != "" {
+		token, err := m.SharedToken(ctx, sc.TokenID)
+		if err != nil {
+			return fmt.Errorf("site %s: %w", sc.SiteID, err)
+		}
+		sc.AccessToken = token
+		return nil
+	}
+	token, err := m.keys.Decrypt(sc.AccessToken, sc.SiteID)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt access token of site %s: %w", sc.SiteID, err)
+	}
+	sc.AccessToken = token
+	return nil
+}
+
+// staleKeyID returns the ID of a key other than the primary one that the stored token
+// or webhook secret of siteID is encrypted with, "" for a value stored in plaintext,
+// and ok false if both are empty or already use the primary key.
+func (m *Manager) staleKeyID(ctx context.Context, siteID string) (keyID string, ok bool, err error) {
+	var stored struct {
+		AccessToken   string `json:"access_token"`
+		WebhookSecret string `json:"webhook_secret"`
+	}
+	if _, err := m.store.GetJSON(ctx, m.siteKey(siteID), &stored); err != nil {
+		return "", false, err
+	}
+	for _, value := range []string{stored.AccessToken, stored.WebhookSecret} {
+		if value != "" && secrets.KeyID(value) != m.keys.PrimaryKeyID() {
+			return secrets.KeyID(value), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// ReencryptTokens rewrites every site and shared token whose stored token or webhook
+// secret is not encrypted with the primary key, returning how many were rewritten. Each site is
+// locked while it is rewritten, waiting up to lockWait for a running sync to finish.
+func (m *Manager) ReencryptTokens(ctx context.Context, lockWait time.Duration) (int, error) {
+	if m.keys == nil {
+		return 0, fmt.Errorf("no encryption keys are configured")
+	}
+	ids, err := m.store.SMembers(ctx, sitesSetKey)
+	if err != nil {
+		return 0, err
+	}
+	rewritten := 0
+	for _, id := range ids {
+		keyID, stale, err := m.staleKeyID(ctx, id)
+		if err != nil {
+			return rewritten, fmt.Errorf("site %s: %w", id, err)
+		}
+		if !stale {
+			continue
+		}
+
+		lock, err := m.LockSite(ctx, id, "rotate-keys", lockWait)
+		if err != nil {
+			return rewritten, err
+		}
+		sc, err := m.GetSite(ctx, id)
+		if err == nil {
+			err = m.UpdateSiteLocked(ctx, sc, lock)
+		}
+		lock.Unlock()
+		if err != nil {
+			return rewritten, fmt.Errorf("site %s: %w", id, err)
+		}
+		if keyID == "" {
+			logger.Log.Infof("Encrypted credentials of site %s with key %s", id, m.keys.PrimaryKeyID())
+		} else {
+			logger.Log.Infof("Re-encrypted credentials of site %s from key %s to %s", id, keyID, m.keys.PrimaryKeyID())
+		}
+		rewritten++
+	}
+	shared, err := m.reencryptSharedTokens(ctx)
+	return rewritten + shared, err
+}
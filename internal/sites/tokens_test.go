@@ -0,0 +1,122 @@
+package sites
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"dify-wp-sync/internal/localstore"
+	"dify-wp-sync/internal/secrets"
+)
+
+func testKeyring(t *testing.T, spec ...string) *secrets.Keyring {
+	t.Helper()
+	entries := make([]string, 0, len(spec))
+	for _, id := range spec {
+		entries = append(entries, id+":"+base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id[:1], 32))))
+	}
+	k, err := secrets.ParseKeys(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+	return k
+}
+
+// storedCredentials returns the token and webhook secret of siteID as stored.
+func storedCredentials(t *testing.T, m *Manager, siteID string) (token, webhookSecret string) {
+	t.Helper()
+	var stored SiteConfig
+	if _, err := m.store.GetJSON(context.Background(), m.siteKey(siteID), &stored); err != nil {
+		t.Fatalf("GetJSON: %v", err)
+	}
+	return stored.AccessToken, stored.WebhookSecret
+}
+
+func TestCredentialsAreEncryptedAtRest(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(localstore.NewMemory(), testKeyring(t, "a1"))
+	err := m.AddSite(ctx, &SiteConfig{SiteID: "42", AccessToken: "token-42", WebhookSecret: "hook-42"})
+	if err != nil {
+		t.Fatalf("AddSite: %v", err)
+	}
+
+	token, secret := storedCredentials(t, m, "42")
+	if secrets.KeyID(token) != "a1" || secrets.KeyID(secret) != "a1" {
+		t.Fatalf("stored token %q and webhook secret %q, want both encrypted with a1", token, secret)
+	}
+	sc, err := m.GetSite(ctx, "42")
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	if sc.AccessToken != "token-42" || sc.WebhookSecret != "hook-42" {
+		t.Errorf("GetSite returned token %q and webhook secret %q, want the plaintext", sc.AccessToken, sc.WebhookSecret)
+	}
+}
+
+func TestReencryptTokensRotatesTokenAndWebhookSecret(t *testing.T) {
+	ctx := context.Background()
+	store := localstore.NewMemory()
+	old := NewManager(store, testKeyring(t, "a1"))
+	for _, id := range []string{"1", "2"} {
+		if err := old.AddSite(ctx, &SiteConfig{SiteID: id, AccessToken: "token-" + id, WebhookSecret: "hook-" + id}); err != nil {
+			t.Fatalf("AddSite: %v", err)
+		}
+	}
+	// A site stored before encryption was enabled.
+	plain := NewManager(store, nil)
+	if err := plain.AddSite(ctx, &SiteConfig{SiteID: "3", AccessToken: "token-3", WebhookSecret: "hook-3"}); err != nil {
+		t.Fatalf("AddSite: %v", err)
+	}
+
+	rotated := NewManager(store, testKeyring(t, "b2", "a1"))
+	n, err := rotated.ReencryptTokens(ctx, time.Second)
+	if err != nil {
+		t.Fatalf("ReencryptTokens: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("ReencryptTokens rewrote %d sites, want 3", n)
+	}
+
+	// Only the new key is needed from now on.
+	current := NewManager(store, testKeyring(t, "b2"))
+	for _, id := range []string{"1", "2", "3"} {
+		token, secret := storedCredentials(t, current, id)
+		if secrets.KeyID(token) != "b2" || secrets.KeyID(secret) != "b2" {
+			t.Errorf("site %s: stored token %q and webhook secret %q, want both encrypted with b2", id, token, secret)
+		}
+		sc, err := current.GetSite(ctx, id)
+		if err != nil {
+			t.Fatalf("GetSite(%s): %v", id, err)
+		}
+		if sc.AccessToken != "token-"+id || sc.WebhookSecret != "hook-"+id {
+			t.Errorf("site %s: got token %q and webhook secret %q", id, sc.AccessToken, sc.WebhookSecret)
+		}
+	}
+
+	if n, err := rotated.ReencryptTokens(ctx, time.Second); err != nil || n != 0 {
+		t.Errorf("second ReencryptTokens = %d, %v; want nothing left to rewrite", n, err)
+	}
+}
+
+func TestWebhookSecretCannotBeSwappedWithToken(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(localstore.NewMemory(), testKeyring(t, "a1"))
+	if err := m.AddSite(ctx, &SiteConfig{SiteID: "42", AccessToken: "token-42", WebhookSecret: "hook-42"}); err != nil {
+		t.Fatalf("AddSite: %v", err)
+	}
+
+	token, _ := storedCredentials(t, m, "42")
+	var raw map[string]interface{}
+	if _, err := m.store.GetJSON(ctx, m.siteKey("42"), &raw); err != nil {
+		t.Fatalf("GetJSON: %v", err)
+	}
+	raw["webhook_secret"] = token
+	if err := m.store.SetJSON(ctx, m.siteKey("42"), raw, 0); err != nil {
+		t.Fatalf("SetJSON: %v", err)
+	}
+	if _, err := m.GetSite(ctx, "42"); err == nil {
+		t.Error("GetSite accepted the encrypted token in place of the webhook secret")
+	}
+}
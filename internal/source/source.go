@@ -0,0 +1,62 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"dify-wp-sync/internal/logger"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+)
+
+// ErrNotFound is returned by GetItem when the source has no live item with the given ID.
+var ErrNotFound = errors.New("item not found")
+
+// ErrUnauthorized is returned when the source rejects the site's credentials, e.g.
+// because the app was revoked. Syncing cannot succeed until the site is re-authorized.
+var ErrUnauthorized = errors.New("credentials were rejected")
+
+// ErrListUnsupported is returned by ListIDs when a source cannot tell which items still exist.
+var ErrListUnsupported = errors.New("source cannot list live items")
+
+// Item is a single piece of content (post, page, ...) in a source-agnostic shape.
+type Item struct {
+	ID       int
+	Title    string
+	Content  string // HTML
+	Type     string
+	URL      string
+	Modified time.Time
+}
+
+// Markdown converts the item's HTML content to Markdown.
+func (it Item) Markdown() string {
+	converter := md.NewConverter("", true, nil)
+	markdown, err := converter.ConvertString(it.Content)
+	if err != nil {
+		// Log error but return original content as fallback
+		logger.Log.Errorf("Failed to convert HTML to Markdown for item %d: %v", it.ID, err)
+		return it.Content
+	}
+	return markdown
+}
+
+// SyncObserver is implemented by sources that keep per-item state, such as ETags for
+// conditional requests, that may only be recorded once the item has been synced;
+// otherwise an item that failed to sync would look unchanged on the next run.
+type SyncObserver interface {
+	ItemSynced(it Item)
+}
+
+// ContentSource is implemented by every system the sync engine can read content from.
+// Implementations apply their own filters (post types, statuses) to everything they return.
+type ContentSource interface {
+	// ListChanged calls fn with successive batches of items modified after since.
+	ListChanged(ctx context.Context, since time.Time, fn func(items []Item) error) error
+	// GetItem fetches a single item, returning ErrNotFound if it is gone.
+	GetItem(ctx context.Context, id int) (*Item, error)
+	// ListIDs returns the IDs of all items that currently exist, for deletion detection,
+	// or ErrListUnsupported if the source cannot know.
+	ListIDs(ctx context.Context) ([]int, error)
+}
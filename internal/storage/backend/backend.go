@@ -0,0 +1,43 @@
+// Package backend opens the storage.Store selected by configuration.
+package backend
+
+import (
+	"fmt"
+	"strings"
+
+	"dify-wp-sync/internal/config"
+	"dify-wp-sync/internal/localstore"
+	"dify-wp-sync/internal/redisstore"
+	"dify-wp-sync/internal/storage"
+)
+
+const (
+	Redis = "redis"
+	Bolt  = "bolt"
+)
+
+// Open opens the backend named by cfg.Store.
+func Open(cfg *config.Config) (storage.Store, error) {
+	return OpenSpec(cfg.Store, cfg)
+}
+
+// OpenSpec opens the backend described by spec: "redis", "bolt" (using cfg.StorePath)
+// or "bolt:<path>". Redis connection settings come from cfg. The in-memory store loses
+// everything, tokens included, when the process exits, so it is not offered here;
+// tests create it with localstore.NewMemory.
+func OpenSpec(spec string, cfg *config.Config) (storage.Store, error) {
+	name, path, _ := strings.Cut(spec, ":")
+	switch name {
+	case Redis:
+		return redisstore.New(cfg.RedisAddr, cfg.RedisPwd, cfg.RedisDB), nil
+	case "memory":
+		return nil, fmt.Errorf("store %q is only available to tests; use redis or bolt", spec)
+	case Bolt:
+		if path == "" {
+			path = cfg.StorePath
+		}
+		return localstore.OpenBolt(path)
+	default:
+		return nil, fmt.Errorf("unknown store %q (expected redis, bolt or bolt:<path>)", spec)
+	}
+}
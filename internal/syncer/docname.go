@@ -0,0 +1,32 @@
+package syncer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Documents are named "<title> [<site ID>:<post ID>]" so the mapping can be rebuilt
+// from the dataset alone (see RebuildMapping), even when several sites share it.
+var docNameSuffix = regexp.MustCompile(` ?\[([^\[\]]+):(\d+)\]$`)
+
+// DocumentName returns the name of the Dify document for a post.
+func DocumentName(siteID string, postID int, title string) string {
+	return strings.TrimSpace(fmt.Sprintf("%s [%s:%d]", strings.TrimSpace(title), siteID, postID))
+}
+
+// ParseDocumentName returns the site and post ID a document name produced by
+// DocumentName refers to. ok is false for other names, such as documents created
+// before names carried the post ID.
+func ParseDocumentName(name string) (siteID string, postID int, ok bool) {
+	m := docNameSuffix.FindStringSubmatch(name)
+	if m == nil {
+		return "", 0, false
+	}
+	postID, err := strconv.Atoi(m[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return m[1], postID, true
+}
@@ -0,0 +1,44 @@
+package syncer
+
+import "testing"
+
+func TestDocumentNameRoundTrip(t *testing.T) {
+	tests := []struct {
+		siteID string
+		postID int
+		title  string
+		want   string
+	}{
+		{"123", 45, "Hello world", "Hello world [123:45]"},
+		{"123", 45, "  padded  ", "padded [123:45]"},
+		{"123", 45, "", "[123:45]"},
+		{"123", 45, "Looks like [999:1] a suffix", "Looks like [999:1] a suffix [123:45]"},
+		{"feed-example.com", 7, "Feed post", "Feed post [feed-example.com:7]"},
+		{"example.com:8080/blog", 7, "Self-hosted on a port", "Self-hosted on a port [example.com:8080/blog:7]"},
+	}
+	for _, tt := range tests {
+		name := DocumentName(tt.siteID, tt.postID, tt.title)
+		if name != tt.want {
+			t.Errorf("DocumentName(%q, %d, %q) = %q, want %q", tt.siteID, tt.postID, tt.title, name, tt.want)
+		}
+		siteID, postID, ok := ParseDocumentName(name)
+		if !ok || siteID != tt.siteID || postID != tt.postID {
+			t.Errorf("ParseDocumentName(%q) = %q, %d, %v; want %q, %d, true", name, siteID, postID, ok, tt.siteID, tt.postID)
+		}
+	}
+}
+
+func TestParseDocumentNameRejectsOtherNames(t *testing.T) {
+	for _, name := range []string{
+		"Legacy title without a suffix",
+		"Title [123:abc]",
+		"Title [123:45] trailing text",
+		"Title [:45]",
+		"Title [123 45]",
+		"",
+	} {
+		if siteID, postID, ok := ParseDocumentName(name); ok {
+			t.Errorf("ParseDocumentName(%q) = %q, %d, true; want ok false", name, siteID, postID)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sink"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/source"
+)
+
+// SyncItem creates or updates the Dify document for a single item, records the
+// mapping through sm, and mirrors the document to the sinks. Sink failures are
+// logged but do not fail the item; the caller flushes the sinks once done with the
+// site. sm may be nil when difyClient is, as the mapping is then left alone.
+func SyncItem(ctx context.Context, sm *sites.Manager, siteCfg *sites.SiteConfig, it source.Item, difyClient *dify.DifyClient, sinks ...sink.Sink) error {
+	markdownContent := it.Markdown()
+
+	if difyClient != nil {
+		if err := upsertDocument(ctx, sm, siteCfg, it, markdownContent, difyClient); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range sinks {
+		if err := s.Put(ctx, siteCfg.SiteID, it, markdownContent); err != nil {
+			logger.Log.Errorf("Failed to write post %d (%s) to sink: %v", it.ID, it.Title, err)
+		}
+	}
+	return nil
+}
+
+func upsertDocument(ctx context.Context, sm *sites.Manager, siteCfg *sites.SiteConfig, it source.Item, markdownContent string, difyClient *dify.DifyClient) error {
+	docID, exists := siteCfg.PostDocMapping[it.ID]
+	name := DocumentName(siteCfg.SiteID, it.ID, it.Title)
+
+	if exists {
+		_, err := difyClient.UpdateDocumentByText(ctx, siteCfg.DifyDatasetID, docID, name, markdownContent)
+		if err == nil {
+			logger.Log.Infof("Updated document %s for post %d (%s)", docID, it.ID, it.Title)
+			return nil
+		}
+		if !dify.IsNotFound(err) {
+			return fmt.Errorf("failed to update doc %s for post %d (%s): %w", docID, it.ID, it.Title, err)
+		}
+		logger.Log.Warnf("Document %s for post %d (%s) no longer exists, creating it again", docID, it.ID, it.Title)
+	}
+
+	datasetID := siteCfg.DifyDatasetID
+	newDocID, err := createDocument(ctx, sm, siteCfg, name, markdownContent, difyClient)
+	if err != nil {
+		return fmt.Errorf("failed to create doc for post %d (%s): %w", it.ID, it.Title, err)
+	}
+	if err := sm.SetDocID(ctx, siteCfg, it.ID, newDocID); err != nil {
+		return fmt.Errorf("created doc %s for post %d (%s) but failed to store mapping: %w", newDocID, it.ID, it.Title, err)
+	}
+	logger.Log.Infof("Created document %s for post %d (%s)", newDocID, it.ID, it.Title)
+
+	// A recreated dataset is recorded on its own; its documents are all new.
+	if exists && siteCfg.DifyDatasetID == datasetID {
+		recordRecovery(ctx, sm, siteCfg, sites.RecoveryDocumentRecreated, it.ID, docID, newDocID)
+	}
+	return nil
+}
+
+// DeleteItem removes the Dify document mapped to postID, if any, drops the mapping
+// through sm, and removes the post from the sinks.
+func DeleteItem(ctx context.Context, sm *sites.Manager, siteCfg *sites.SiteConfig, postID int, difyClient *dify.DifyClient, sinks ...sink.Sink) error {
+	if docID, exists := siteCfg.PostDocMapping[postID]; exists {
+		err := difyClient.DeleteDocument(ctx, siteCfg.DifyDatasetID, docID)
+		if dify.IsNotFound(err) {
+			logger.Log.Infof("Document %s for post %d was already deleted", docID, postID)
+		} else if err != nil {
+			return fmt.Errorf("failed to delete doc %s for post %d: %w", docID, postID, err)
+		}
+		if err := sm.RemoveDocID(ctx, siteCfg, postID); err != nil {
+			return fmt.Errorf("deleted doc %s for post %d but failed to remove mapping: %w", docID, postID, err)
+		}
+		logger.Log.Infof("Deleted document %s for removed post %d", docID, postID)
+	}
+
+	for _, s := range sinks {
+		if err := s.Delete(ctx, siteCfg.SiteID, postID); err != nil {
+			logger.Log.Errorf("Failed to remove post %d from sink: %v", postID, err)
+		}
+	}
+	return nil
+}
+
+// flushSinks has the sinks persist what they buffered for the site. Failures are
+// logged like other sink failures.
+func flushSinks(ctx context.Context, siteID string, sinks ...sink.Sink) {
+	for _, s := range sinks {
+		if err := s.Flush(ctx, siteID); err != nil {
+			logger.Log.Errorf("Failed to flush sink for site %s: %v", siteID, err)
+		}
+	}
+}
+
+// pruneDeleted removes documents whose items no longer exist in the source. A listing
+// can miss items that move between pages while it runs, so each item missing from it
+// is looked up on its own and only deleted once the source confirms it is gone.
+// Failures are logged rather than returned so they never block a sync. The site's
+// last prune time is updated after a complete pass.
+func pruneDeleted(ctx context.Context, sm *sites.Manager, siteCfg *sites.SiteConfig, src source.ContentSource, difyClient *dify.DifyClient, sinks ...sink.Sink) {
+	defer flushSinks(ctx, siteCfg.SiteID, sinks...)
+	if len(siteCfg.PostDocMapping) == 0 {
+		siteCfg.LastPruneTime = time.Now().UTC()
+		return
+	}
+	ids, err := src.ListIDs(ctx)
+	if errors.Is(err, source.ErrListUnsupported) {
+		siteCfg.LastPruneTime = time.Now().UTC()
+		return
+	}
+	if err != nil {
+		logger.Log.Warnf("Skipping deletion detection for site %s: %v", siteCfg.SiteID, err)
+		return
+	}
+	if len(ids) == 0 {
+		// An empty listing is far more likely to be an API hiccup than a wiped site.
+		logger.Log.Warnf("Source for site %s listed no items; refusing to delete %d documents",
+			siteCfg.SiteID, len(siteCfg.PostDocMapping))
+		return
+	}
+
+	live := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		live[id] = true
+	}
+	for postID := range siteCfg.PostDocMapping {
+		if live[postID] {
+			continue
+		}
+		_, err := src.GetItem(ctx, postID)
+		if err == nil {
+			logger.Log.Infof("Post %d of site %s was missing from the listing but still exists, keeping its document", postID, siteCfg.SiteID)
+			continue
+		}
+		if !errors.Is(err, source.ErrNotFound) {
+			logger.Log.Warnf("Could not confirm that post %d of site %s was deleted, keeping its document: %v", postID, siteCfg.SiteID, err)
+			continue
+		}
+		if err := DeleteItem(ctx, sm, siteCfg, postID, difyClient, sinks...); err != nil {
+			logger.Log.Errorf("%v", err)
+		}
+	}
+	siteCfg.LastPruneTime = time.Now().UTC()
+}
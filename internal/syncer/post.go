@@ -0,0 +1,87 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sink"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/source"
+)
+
+// PostChange is a notified change to a single post.
+type PostChange struct {
+	PostID   int
+	Modified time.Time // The post's modification time after the change
+	Trash    bool
+}
+
+// SyncPost applies a notified change to one post of an active registered site. The post is
+// always re-fetched from the source rather than trusting the notification, so a publish
+// for a post that has since been trashed results in a deletion. Changes no newer than
+// the last one applied to the post are skipped; the check and the recorded version are
+// both made under the site's lock, and the version only once the change is applied.
+func SyncPost(ctx context.Context, sm *sites.Manager, difyClient *dify.DifyClient, siteID string, change PostChange, lockWait time.Duration, sinks ...sink.Sink) error {
+	lock, err := sm.LockSite(ctx, siteID, "webhook", lockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	ctx = lock.Context()
+
+	sc, err := sm.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	// Sites needing reauthorization or otherwise disabled are left alone; the change is
+	// picked up by the first full sync after the site is active again.
+	if !sc.Active() {
+		return fmt.Errorf("site %s is %s; not applying the change to post %d", siteID, sc.Status, change.PostID)
+	}
+
+	applied, err := sm.PostVersion(ctx, siteID, change.PostID)
+	if err != nil {
+		return err
+	}
+	if !change.Modified.After(applied) {
+		logger.Log.Infof("Skipping stale change to post %d on site %s (modified %s, already applied %s)",
+			change.PostID, siteID, change.Modified, applied)
+		return nil
+	}
+
+	if err := applyPostChange(ctx, sm, sc, change, difyClient, sinks...); err != nil {
+		return err
+	}
+	if err := sm.UpdateSiteLocked(ctx, sc, lock); err != nil {
+		return err
+	}
+	return sm.SetPostVersion(ctx, siteID, change.PostID, change.Modified)
+}
+
+func applyPostChange(ctx context.Context, sm *sites.Manager, sc *sites.SiteConfig, change PostChange, difyClient *dify.DifyClient, sinks ...sink.Sink) error {
+	defer flushSinks(ctx, sc.SiteID, sinks...)
+	if change.Trash {
+		return DeleteItem(ctx, sm, sc, change.PostID, difyClient, sinks...)
+	}
+
+	src, err := NewSource(sc, sm.WPComAPIBase)
+	if err != nil {
+		return err
+	}
+	it, err := src.GetItem(ctx, change.PostID)
+	switch {
+	case errors.Is(err, source.ErrNotFound):
+		logger.Log.Infof("Post %d on site %s is no longer published, removing its document", change.PostID, sc.SiteID)
+		return DeleteItem(ctx, sm, sc, change.PostID, difyClient, sinks...)
+	case err != nil:
+		return err
+	case it.Content == "":
+		logger.Log.Warnf("Post %d (%s) has empty content, skipping creation/update", it.ID, it.Title)
+		return nil
+	}
+	return SyncItem(ctx, sm, sc, *it, difyClient, sinks...)
+}
@@ -0,0 +1,138 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sites"
+)
+
+// listPageSize is the page size used to list a dataset's documents; Dify caps it at 100.
+const listPageSize = 100
+
+// RebuildReport describes the mapping RebuildMapping arrived at.
+type RebuildReport struct {
+	DatasetID  string
+	Mapping    map[int]string   // The rebuilt mapping
+	Documents  int              // Documents in the dataset
+	Mapped     int              // Posts mapped to a document named after them
+	Kept       int              // Previous mapping entries kept for documents without a post ID in their name
+	Dropped    int              // Previous mapping entries whose documents no longer exist
+	OtherSites int              // Documents named after posts of other sites sharing the dataset
+	Duplicates map[int][]string // Post ID to the documents left unmapped because another one was chosen
+	Unmatched  []dify.DocumentInfo
+}
+
+// RebuildMapping replaces the site's post-to-document mapping with one recovered from
+// the names of the documents in its dataset (see DocumentName), for when the mapping
+// was lost and the next sync would otherwise create every document again. When a post
+// has several documents, the one already mapped is kept, or else the newest. Previous
+// entries for documents that still exist but predate post IDs in names are kept too.
+func RebuildMapping(ctx context.Context, sm *sites.Manager, difyClient *dify.DifyClient, siteID string, lockWait time.Duration) (*RebuildReport, error) {
+	lock, err := sm.LockSite(ctx, siteID, "rebuild-mapping", lockWait)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	ctx = lock.Context()
+
+	sc, err := sm.GetSite(ctx, siteID)
+	if err != nil {
+		return nil, err
+	}
+	if sc.DifyDatasetID == "" {
+		return nil, fmt.Errorf("site %s has no dataset", siteID)
+	}
+
+	docs, err := listAllDocuments(ctx, difyClient, sc.DifyDatasetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents of dataset %s: %w", sc.DifyDatasetID, err)
+	}
+
+	report := &RebuildReport{DatasetID: sc.DifyDatasetID, Documents: len(docs), Duplicates: make(map[int][]string)}
+	mappedTo := make(map[string]int, len(sc.PostDocMapping))
+	for postID, docID := range sc.PostDocMapping {
+		mappedTo[docID] = postID
+	}
+
+	// Documents are listed newest first, so the first one seen for a post is the newest.
+	candidates := make(map[int][]string)
+	var legacy []dify.DocumentInfo
+	for _, doc := range docs {
+		docSite, postID, ok := ParseDocumentName(doc.Name)
+		switch {
+		case !ok:
+			legacy = append(legacy, doc)
+		case docSite != siteID:
+			report.OtherSites++
+		default:
+			candidates[postID] = append(candidates[postID], doc.ID)
+		}
+	}
+
+	mapping := make(map[int]string, len(candidates))
+	for postID, docIDs := range candidates {
+		chosen := docIDs[0]
+		for _, docID := range docIDs {
+			if sc.PostDocMapping[postID] == docID {
+				chosen = docID
+			}
+		}
+		mapping[postID] = chosen
+		for _, docID := range docIDs {
+			if docID != chosen {
+				report.Duplicates[postID] = append(report.Duplicates[postID], docID)
+			}
+		}
+	}
+	report.Mapped = len(mapping)
+
+	existing := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		existing[doc.ID] = true
+	}
+	for _, doc := range legacy {
+		postID, wasMapped := mappedTo[doc.ID]
+		_, taken := mapping[postID]
+		switch {
+		case wasMapped && taken:
+			report.Duplicates[postID] = append(report.Duplicates[postID], doc.ID)
+		case wasMapped:
+			mapping[postID] = doc.ID
+			report.Kept++
+		default:
+			report.Unmatched = append(report.Unmatched, doc)
+		}
+	}
+	for _, docID := range sc.PostDocMapping {
+		if !existing[docID] {
+			report.Dropped++
+		}
+	}
+
+	if err := sm.ReplaceDocIDs(ctx, sc, mapping); err != nil {
+		return nil, fmt.Errorf("failed to store mapping; the previous one is unchanged: %w", err)
+	}
+	report.Mapping = mapping
+	logger.Log.Infof("Rebuilt mapping of site %s from %d documents in dataset %s: %d posts mapped, %d duplicates, %d unmatched",
+		siteID, len(docs), sc.DifyDatasetID, len(mapping), len(report.Duplicates), len(report.Unmatched))
+	return report, nil
+}
+
+// listAllDocuments returns every document in the dataset, newest first.
+func listAllDocuments(ctx context.Context, difyClient *dify.DifyClient, datasetID string) ([]dify.DocumentInfo, error) {
+	var docs []dify.DocumentInfo
+	for page := 1; ; page++ {
+		listResp, err := difyClient.ListDocuments(ctx, datasetID, page, listPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", page, err)
+		}
+		docs = append(docs, listResp.Data...)
+		if !listResp.HasMore || len(listResp.Data) == 0 {
+			return docs, nil
+		}
+	}
+}
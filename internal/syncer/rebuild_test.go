@@ -0,0 +1,107 @@
+package syncer
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/difytest"
+	"dify-wp-sync/internal/localstore"
+	"dify-wp-sync/internal/sites"
+)
+
+const rebuildSiteID = "1001"
+
+// newRebuildEnv registers a site with an empty dataset in a fake Dify.
+func newRebuildEnv(t *testing.T) (*sites.Manager, *dify.DifyClient, *sites.SiteConfig) {
+	t.Helper()
+	df := difytest.NewServer()
+	t.Cleanup(df.Close)
+	sm := sites.NewManager(localstore.NewMemory(), nil)
+	sc := &sites.SiteConfig{SiteID: rebuildSiteID, DifyDatasetID: df.AddDataset("https://example.com"), Status: sites.StatusActive}
+	if err := sm.AddSite(context.Background(), sc); err != nil {
+		t.Fatalf("AddSite: %v", err)
+	}
+	return sm, dify.NewDifyClient(difytest.APIKey, df.BaseURL()), sc
+}
+
+func TestRebuildMapping(t *testing.T) {
+	sm, dc, site := newRebuildEnv(t)
+	ctx := context.Background()
+	datasetID := site.DifyDatasetID
+	create := func(name string) string {
+		t.Helper()
+		id, err := dc.CreateDocumentByText(ctx, datasetID, name, "text")
+		if err != nil {
+			t.Fatalf("CreateDocumentByText: %v", err)
+		}
+		return id
+	}
+
+	// Created oldest first; Dify lists them newest first.
+	older1 := create(DocumentName(rebuildSiteID, 1, "One"))
+	newer1 := create(DocumentName(rebuildSiteID, 1, "One"))
+	doc2 := create(DocumentName(rebuildSiteID, 2, "Two"))
+	older5 := create(DocumentName(rebuildSiteID, 5, "Five"))
+	newer5 := create(DocumentName(rebuildSiteID, 5, "Five"))
+	create(DocumentName("2002", 7, "Another site's post"))
+	legacy3 := create("Three, named before post IDs")
+	create("Unknown legacy document")
+
+	// The previous mapping keeps the older document of post 5, knows the legacy document
+	// of post 3 and points post 4 at a document that no longer exists.
+	for postID, docID := range map[int]string{3: legacy3, 4: "doc-gone", 5: older5} {
+		if err := sm.SetDocID(ctx, site, postID, docID); err != nil {
+			t.Fatalf("SetDocID: %v", err)
+		}
+	}
+
+	report, err := RebuildMapping(ctx, sm, dc, rebuildSiteID, 0)
+	if err != nil {
+		t.Fatalf("RebuildMapping: %v", err)
+	}
+
+	want := map[int]string{1: newer1, 2: doc2, 3: legacy3, 5: older5}
+	if !maps.Equal(report.Mapping, want) {
+		t.Errorf("Mapping = %v, want %v", report.Mapping, want)
+	}
+	stored, err := sm.GetPostDocMapping(ctx, rebuildSiteID)
+	if err != nil {
+		t.Fatalf("GetPostDocMapping: %v", err)
+	}
+	if !maps.Equal(stored, want) {
+		t.Errorf("stored mapping = %v, want %v", stored, want)
+	}
+
+	if report.Documents != 8 || report.Mapped != 3 || report.Kept != 1 || report.Dropped != 1 || report.OtherSites != 1 {
+		t.Errorf("report counts: documents %d, mapped %d, kept %d, dropped %d, other sites %d; want 8, 3, 1, 1, 1",
+			report.Documents, report.Mapped, report.Kept, report.Dropped, report.OtherSites)
+	}
+	if len(report.Duplicates) != 2 || report.Duplicates[1][0] != older1 || report.Duplicates[5][0] != newer5 {
+		t.Errorf("Duplicates = %v, want post 1: [%s] and post 5: [%s]", report.Duplicates, older1, newer5)
+	}
+	if len(report.Unmatched) != 1 || report.Unmatched[0].Name != "Unknown legacy document" {
+		t.Errorf("Unmatched = %v, want only the unknown legacy document", report.Unmatched)
+	}
+}
+
+func TestRebuildMappingOfEmptyDatasetClearsMapping(t *testing.T) {
+	sm, dc, site := newRebuildEnv(t)
+	ctx := context.Background()
+	if err := sm.SetDocID(ctx, site, 1, "doc-gone"); err != nil {
+		t.Fatalf("SetDocID: %v", err)
+	}
+
+	report, err := RebuildMapping(ctx, sm, dc, rebuildSiteID, 0)
+	if err != nil {
+		t.Fatalf("RebuildMapping: %v", err)
+	}
+	if len(report.Mapping) != 0 || report.Dropped != 1 {
+		t.Errorf("Mapping = %v with %d dropped, want empty with 1 dropped", report.Mapping, report.Dropped)
+	}
+	stored, err := sm.GetPostDocMapping(ctx, rebuildSiteID)
+	if err != nil || len(stored) != 0 {
+		t.Errorf("stored mapping = %v, %v; want empty", stored, err)
+	}
+}
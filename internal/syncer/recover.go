@@ -0,0 +1,99 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sites"
+)
+
+// Documents and datasets can be deleted in the Dify UI behind our back. Rather than
+// failing on the stale IDs forever, a sync that finds them gone creates them again and
+// records what it replaced in the site's recovery log.
+
+// createDocument creates a document named name in the site's dataset. If Dify reports
+// the dataset itself missing, a new dataset replaces it first; see recreateDataset.
+// A 404 alone is not taken as proof, as a proxy or a changed API path can answer one
+// too: the dataset is only replaced once the dataset listing confirms it is gone.
+func createDocument(ctx context.Context, sm *sites.Manager, siteCfg *sites.SiteConfig, name, markdownContent string, difyClient *dify.DifyClient) (string, error) {
+	docID, err := difyClient.CreateDocumentByText(ctx, siteCfg.DifyDatasetID, name, markdownContent)
+	if !dify.IsNotFound(err) {
+		return docID, err
+	}
+	exists, existsErr := difyClient.DatasetExists(ctx, siteCfg.DifyDatasetID)
+	if existsErr != nil {
+		return "", fmt.Errorf("%w (checking whether dataset %s still exists failed: %w)", err, siteCfg.DifyDatasetID, existsErr)
+	}
+	if exists {
+		return "", fmt.Errorf("%w (dataset %s still exists, so it is not replaced)", err, siteCfg.DifyDatasetID)
+	}
+	if err := recreateDataset(ctx, sm, siteCfg, difyClient); err != nil {
+		return "", err
+	}
+	return difyClient.CreateDocumentByText(ctx, siteCfg.DifyDatasetID, name, markdownContent)
+}
+
+// recreateDataset gives the site a new dataset in place of its missing one. Every
+// mapped document went with the old dataset, so the mapping is cleared and the last
+// sync time and feed ETags are reset for the next full sync to fill the new one. A
+// dataset other sites also sync into is left for the operator to fix, as giving each
+// of them its own replacement would split what was one dataset, and so is one whose
+// other users cannot be told because a site record does not load. The caller must hold
+// the site's lock and save the config afterwards.
+func recreateDataset(ctx context.Context, sm *sites.Manager, siteCfg *sites.SiteConfig, difyClient *dify.DifyClient) error {
+	oldID := siteCfg.DifyDatasetID
+	users, err := sm.DatasetUsers(ctx, oldID)
+	if err != nil {
+		return fmt.Errorf("dataset %s of site %s no longer exists and checking which sites use it failed: %w", oldID, siteCfg.SiteID, err)
+	}
+	for _, other := range users {
+		if other != siteCfg.SiteID {
+			return fmt.Errorf("dataset %s of site %s no longer exists but is also used by site %s; not replacing it automatically, use fix-dataset: %w",
+				oldID, siteCfg.SiteID, other, ErrDatasetShared)
+		}
+	}
+	name := siteCfg.BlogURL
+	if name == "" {
+		name = siteCfg.SiteID
+	}
+	newID, err := difyClient.CreateDataset(ctx, name)
+	var apiErr *dify.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == dify.CodeDatasetNameDuplicate {
+		// Another dataset already uses the blog URL as its name, e.g. one the site
+		// was moved away from by a fresh reconnect.
+		newID, err = difyClient.CreateDataset(ctx, fmt.Sprintf("%s (%s)", name, time.Now().UTC().Format("2006-01-02 15:04:05")))
+	}
+	if err != nil {
+		return fmt.Errorf("dataset %s of site %s no longer exists and creating a new one failed: %w", oldID, siteCfg.SiteID, err)
+	}
+
+	if err := sm.ClearDocIDs(ctx, siteCfg); err != nil {
+		return fmt.Errorf("created dataset %s to replace missing dataset %s but failed to clear the mapping: %w", newID, oldID, err)
+	}
+	siteCfg.DifyDatasetID = newID
+	siteCfg.LastSyncTime = time.Time{}
+	clear(siteCfg.FeedETags)
+	logger.Log.Warnf("Dataset %s of site %s no longer exists; created dataset %s to replace it", oldID, siteCfg.SiteID, newID)
+	recordRecovery(ctx, sm, siteCfg, sites.RecoveryDatasetRecreated, 0, oldID, newID)
+	return nil
+}
+
+// recordRecovery adds an event to the site's recovery log. A failure is only logged,
+// as the recovery itself succeeded.
+func recordRecovery(ctx context.Context, sm *sites.Manager, siteCfg *sites.SiteConfig, kind string, postID int, oldID, newID string) {
+	err := sm.RecordRecovery(ctx, &sites.Recovery{
+		SiteID: siteCfg.SiteID,
+		At:     time.Now().UTC(),
+		Kind:   kind,
+		PostID: postID,
+		OldID:  oldID,
+		NewID:  newID,
+	})
+	if err != nil {
+		logger.Log.Warnf("Failed to record %s recovery for site %s: %v", kind, siteCfg.SiteID, err)
+	}
+}
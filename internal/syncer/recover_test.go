@@ -0,0 +1,225 @@
+package syncer
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/difytest"
+	"dify-wp-sync/internal/localstore"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/source"
+	"dify-wp-sync/internal/storage"
+)
+
+// stubSource is a content source holding its items in memory.
+type stubSource map[int]source.Item
+
+func (s stubSource) ListChanged(ctx context.Context, since time.Time, fn func(items []source.Item) error) error {
+	var items []source.Item
+	for _, it := range s {
+		if it.Modified.After(since) {
+			items = append(items, it)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return fn(items)
+}
+
+func (s stubSource) GetItem(ctx context.Context, id int) (*source.Item, error) {
+	it, ok := s[id]
+	if !ok {
+		return nil, source.ErrNotFound
+	}
+	return &it, nil
+}
+
+func (s stubSource) ListIDs(ctx context.Context) ([]int, error) {
+	return nil, source.ErrListUnsupported
+}
+
+// recoveryEnv is a site syncing from a stubSource into a fake Dify, with its state in
+// an in-memory store.
+type recoveryEnv struct {
+	store storage.Store
+	dify  *difytest.Server
+	sm    *sites.Manager
+	dc    *dify.DifyClient
+	site  *sites.SiteConfig
+	src   stubSource
+}
+
+func newRecoveryEnv(t *testing.T) *recoveryEnv {
+	t.Helper()
+	df := difytest.NewServer()
+	t.Cleanup(df.Close)
+	store := localstore.NewMemory()
+	env := &recoveryEnv{
+		store: store,
+		dify:  df,
+		sm:    sites.NewManager(store, nil),
+		dc:    dify.NewDifyClient(difytest.APIKey, df.BaseURL()),
+		src:   make(stubSource),
+	}
+	env.site = env.addSite(t, "1001", df.AddDataset("https://example.com"))
+	return env
+}
+
+func (e *recoveryEnv) addSite(t *testing.T, siteID, datasetID string) *sites.SiteConfig {
+	t.Helper()
+	sc := &sites.SiteConfig{SiteID: siteID, BlogURL: "https://example.com", DifyDatasetID: datasetID, Status: sites.StatusActive}
+	if err := e.sm.AddSite(context.Background(), sc); err != nil {
+		t.Fatalf("AddSite: %v", err)
+	}
+	return sc
+}
+
+// post adds or replaces an item, modified at the given offset from a fixed time.
+func (e *recoveryEnv) post(id int, content string, modified time.Duration) {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Add(modified)
+	e.src[id] = source.Item{ID: id, Title: "Post", Content: content, Modified: at}
+}
+
+func (e *recoveryEnv) run(t *testing.T) error {
+	t.Helper()
+	return Run(context.Background(), e.sm, e.site, e.src, e.dc)
+}
+
+func (e *recoveryEnv) recoveries(t *testing.T) []*sites.Recovery {
+	t.Helper()
+	recoveries, err := e.sm.ListRecoveries(context.Background(), e.site.SiteID, 10)
+	if err != nil {
+		t.Fatalf("ListRecoveries: %v", err)
+	}
+	return recoveries
+}
+
+func TestSyncRecreatesDeletedDocument(t *testing.T) {
+	env := newRecoveryEnv(t)
+	env.post(1, "<p>v1</p>", 0)
+	if err := env.run(t); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	oldDoc := env.site.PostDocMapping[1]
+
+	env.dify.DeleteDocument(env.site.DifyDatasetID, oldDoc)
+	env.post(1, "<p>v2</p>", time.Hour)
+	if err := env.run(t); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	newDoc := env.site.PostDocMapping[1]
+	if newDoc == "" || newDoc == oldDoc {
+		t.Fatalf("post 1 is mapped to %q after its document %s was deleted, want a new document", newDoc, oldDoc)
+	}
+	if _, ok := env.dify.Document(env.site.DifyDatasetID, newDoc); !ok {
+		t.Fatalf("new document %s does not exist", newDoc)
+	}
+	recoveries := env.recoveries(t)
+	if len(recoveries) != 1 || recoveries[0].Kind != sites.RecoveryDocumentRecreated ||
+		recoveries[0].PostID != 1 || recoveries[0].OldID != oldDoc || recoveries[0].NewID != newDoc {
+		t.Errorf("recoveries = %+v, want one document recreation of post 1 from %s to %s", recoveries, oldDoc, newDoc)
+	}
+}
+
+func TestSyncRecreatesDeletedDataset(t *testing.T) {
+	env := newRecoveryEnv(t)
+	env.post(1, "<p>old post</p>", 0)
+	if err := env.run(t); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	oldDataset := env.site.DifyDatasetID
+
+	env.dify.DeleteDataset(oldDataset)
+	env.post(2, "<p>new post</p>", time.Hour)
+	if err := env.run(t); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if env.site.DifyDatasetID == oldDataset || env.site.DifyDatasetID == "" {
+		t.Fatalf("site still uses dataset %q after %s was deleted", env.site.DifyDatasetID, oldDataset)
+	}
+	// The new dataset is filled with every post, not only the one that found it missing.
+	if docs := env.dify.Documents(env.site.DifyDatasetID); len(docs) != 2 || len(env.site.PostDocMapping) != 2 {
+		t.Errorf("new dataset has %d documents and the mapping %d entries, want 2 each", len(docs), len(env.site.PostDocMapping))
+	}
+	recoveries := env.recoveries(t)
+	if len(recoveries) != 1 || recoveries[0].Kind != sites.RecoveryDatasetRecreated ||
+		recoveries[0].OldID != oldDataset || recoveries[0].NewID != env.site.DifyDatasetID {
+		t.Errorf("recoveries = %+v, want one dataset recreation from %s to %s", recoveries, oldDataset, env.site.DifyDatasetID)
+	}
+}
+
+func TestSyncKeepsDatasetThatStillExistsOnNotFound(t *testing.T) {
+	env := newRecoveryEnv(t)
+	datasetID := env.site.DifyDatasetID
+	env.post(1, "<p>content</p>", 0)
+	env.dify.Inject(difytest.Fault{Method: "POST", Path: "/document/create", Status: 404, Times: 1})
+
+	if err := env.run(t); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if env.site.DifyDatasetID != datasetID {
+		t.Fatalf("dataset was replaced by %s after a stray 404", env.site.DifyDatasetID)
+	}
+	if len(env.site.PostDocMapping) != 0 || !env.site.LastSyncTime.IsZero() {
+		t.Errorf("failed post was recorded: mapping %v, last sync %s", env.site.PostDocMapping, env.site.LastSyncTime)
+	}
+	if n := len(env.dify.Datasets()); n != 1 {
+		t.Errorf("fake Dify has %d datasets, want the original one only", n)
+	}
+	if recoveries := env.recoveries(t); len(recoveries) != 0 {
+		t.Errorf("recoveries = %+v, want none", recoveries)
+	}
+}
+
+func TestSyncRefusesToRecreateSharedDataset(t *testing.T) {
+	env := newRecoveryEnv(t)
+	datasetID := env.site.DifyDatasetID
+	env.addSite(t, "2002", datasetID)
+	env.post(1, "<p>content</p>", 0)
+
+	env.dify.DeleteDataset(datasetID)
+	if err := env.run(t); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if env.site.DifyDatasetID != datasetID {
+		t.Fatalf("shared dataset %s was replaced by %s", datasetID, env.site.DifyDatasetID)
+	}
+	if n := len(env.dify.Datasets()); n != 0 {
+		t.Errorf("fake Dify has %d datasets, want none created", n)
+	}
+	if recoveries := env.recoveries(t); len(recoveries) != 0 {
+		t.Errorf("recoveries = %+v, want none", recoveries)
+	}
+}
+
+func TestSyncRefusesToRecreateDatasetWhenASiteDoesNotLoad(t *testing.T) {
+	env := newRecoveryEnv(t)
+	datasetID := env.site.DifyDatasetID
+	ctx := context.Background()
+	// A site record that cannot be read might use the same dataset.
+	if err := env.store.SetJSON(ctx, "wp_site:2002", "not a site record", 0); err != nil {
+		t.Fatalf("SetJSON: %v", err)
+	}
+	if err := env.store.SAdd(ctx, "wp_sites", "2002"); err != nil {
+		t.Fatalf("SAdd: %v", err)
+	}
+	env.post(1, "<p>content</p>", 0)
+
+	env.dify.DeleteDataset(datasetID)
+	if err := env.run(t); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if env.site.DifyDatasetID != datasetID {
+		t.Fatalf("dataset %s was replaced by %s", datasetID, env.site.DifyDatasetID)
+	}
+	if n := len(env.dify.Datasets()); n != 0 {
+		t.Errorf("fake Dify has %d datasets, want none created", n)
+	}
+	if recoveries := env.recoveries(t); len(recoveries) != 0 {
+		t.Errorf("recoveries = %+v, want none", recoveries)
+	}
+}
@@ -0,0 +1,113 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sink"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/source"
+)
+
+// RunOptions control a sync of a registered site.
+type RunOptions struct {
+	// Trigger is recorded in the site's sync history ("cli", "scheduler", ...).
+	Trigger string
+	// LockWait is how long to wait for another sync of the same site to finish.
+	// Zero fails immediately with sites.ErrSyncInProgress.
+	LockWait time.Duration
+	// Reset clears the post mapping and last sync time first, recreating every document.
+	Reset bool
+	// Prune looks for deleted items even if that was done less than PruneInterval ago.
+	Prune bool
+	Sinks []sink.Sink
+}
+
+// PruneInterval is how often a sync of a registered site lists every item of its
+// source to remove the documents of deleted ones. Listing is expensive for large
+// sites, so other syncs only handle changed items.
+const PruneInterval = 24 * time.Hour
+
+// SyncRegisteredSite takes the site's sync lock, loads the site, syncs it, saves the
+// updated config and records the run's outcome. It is the shared entry point for every
+// component that syncs a whole site.
+func SyncRegisteredSite(ctx context.Context, sm *sites.Manager, difyClient *dify.DifyClient, siteID string, opts RunOptions) error {
+	run := &sites.SyncRun{SiteID: siteID, Trigger: opts.Trigger, StartedAt: time.Now().UTC()}
+	err := syncLocked(ctx, sm, difyClient, siteID, opts)
+
+	run.FinishedAt = time.Now().UTC()
+	switch {
+	case err == nil:
+		run.Status = sites.RunSucceeded
+	case errors.Is(err, sites.ErrSyncInProgress):
+		run.Status = sites.RunSkipped
+		run.Error = err.Error()
+	default:
+		run.Status = sites.RunFailed
+		run.Error = err.Error()
+	}
+	if recErr := sm.RecordSyncRun(ctx, run); recErr != nil {
+		logger.Log.Warnf("Failed to record sync run for site %s: %v", siteID, recErr)
+	}
+	return err
+}
+
+func syncLocked(ctx context.Context, sm *sites.Manager, difyClient *dify.DifyClient, siteID string, opts RunOptions) error {
+	lock, err := sm.LockSite(ctx, siteID, opts.Trigger, opts.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	ctx = lock.Context()
+
+	// Load only after locking so we see the previous holder's final state.
+	sc, err := sm.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+	if !sc.Active() {
+		return fmt.Errorf("site %s is %s", siteID, sc.Status)
+	}
+	if opts.Reset {
+		if err := sm.ClearDocIDs(ctx, sc); err != nil {
+			return err
+		}
+		sc.LastSyncTime = time.Time{}
+		if err := sm.UpdateSiteLocked(ctx, sc, lock); err != nil {
+			return err
+		}
+	}
+	src, err := NewSource(sc, sm.WPComAPIBase)
+	if err != nil {
+		return err
+	}
+	if err := Run(ctx, sm, sc, src, difyClient, opts.Sinks...); err != nil {
+		if errors.Is(err, source.ErrUnauthorized) {
+			markNeedsReauth(ctx, sm, siteID, lock)
+		}
+		return err
+	}
+	if difyClient != nil && (opts.Prune || time.Since(sc.LastPruneTime) >= PruneInterval) {
+		pruneDeleted(ctx, sm, sc, src, difyClient, opts.Sinks...)
+	}
+	return sm.UpdateSiteLocked(ctx, sc, lock)
+}
+
+// markNeedsReauth sets the site's status to needs_reauth so it is skipped until it is
+// reconnected. The site is reloaded first so the failed run's partial state is not saved.
+func markNeedsReauth(ctx context.Context, sm *sites.Manager, siteID string, lock *sites.SiteLock) {
+	sc, err := sm.GetSite(ctx, siteID)
+	if err == nil {
+		sc.Status = sites.StatusNeedsReauth
+		err = sm.UpdateSiteLocked(ctx, sc, lock)
+	}
+	if err != nil {
+		logger.Log.Warnf("Failed to mark site %s as needing re-authorization: %v", siteID, err)
+		return
+	}
+	logger.Log.Warnf("Site %s rejected its credentials and is marked %s; reconnect it to resume syncing", siteID, sites.StatusNeedsReauth)
+}
@@ -0,0 +1,109 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sites"
+)
+
+// What RemoveSite does with the site's Dify content.
+const (
+	CleanupKeep      = "keep"      // Leave the dataset and its documents untouched
+	CleanupDocuments = "documents" // Delete only the documents this tool created
+	CleanupDataset   = "dataset"   // Delete the whole dataset
+)
+
+// ErrDatasetShared is returned when asked to delete a dataset another site still uses.
+var ErrDatasetShared = errors.New("dataset is shared with another site")
+
+// RemoveOptions control the removal of a registered site.
+type RemoveOptions struct {
+	// Cleanup is one of CleanupKeep, CleanupDocuments or CleanupDataset.
+	Cleanup string
+	// LockWait is how long to wait for a running sync of the site to finish.
+	LockWait time.Duration
+}
+
+// RemoveSite deletes a site and, depending on opts.Cleanup, its Dify content. The
+// stored token is forgotten with the rest of the config. Dify content is cleaned up
+// first, so a removal that fails part way can simply be run again.
+func RemoveSite(ctx context.Context, sm *sites.Manager, difyClient *dify.DifyClient, siteID string, opts RemoveOptions) error {
+	switch opts.Cleanup {
+	case CleanupKeep, CleanupDocuments, CleanupDataset:
+	default:
+		return fmt.Errorf("unknown cleanup %q", opts.Cleanup)
+	}
+
+	lock, err := sm.LockSite(ctx, siteID, "remove-site", opts.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	ctx = lock.Context()
+
+	sc, err := sm.GetSite(ctx, siteID)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Cleanup {
+	case CleanupDocuments:
+		if err := deleteMappedDocuments(ctx, sm, sc, difyClient); err != nil {
+			return err
+		}
+	case CleanupDataset:
+		if err := checkDatasetUnshared(ctx, sm, sc); err != nil {
+			return err
+		}
+		if sc.DifyDatasetID != "" {
+			if err := difyClient.DeleteDataset(ctx, sc.DifyDatasetID); err != nil && !dify.IsNotFound(err) {
+				return fmt.Errorf("failed to delete dataset %s: %w", sc.DifyDatasetID, err)
+			}
+			logger.Log.Infof("Deleted dataset %s of site %s", sc.DifyDatasetID, siteID)
+		}
+	}
+
+	if err := sm.DeleteSite(ctx, lock); err != nil {
+		return err
+	}
+	logger.Log.Infof("Removed site %s (%s)", siteID, sc.BlogURL)
+	return nil
+}
+
+// deleteMappedDocuments deletes every document in the site's mapping, forgetting each
+// mapping entry as soon as its document is gone.
+func deleteMappedDocuments(ctx context.Context, sm *sites.Manager, sc *sites.SiteConfig, difyClient *dify.DifyClient) error {
+	deleted := 0
+	for postID, docID := range sc.PostDocMapping {
+		if err := difyClient.DeleteDocument(ctx, sc.DifyDatasetID, docID); err != nil && !dify.IsNotFound(err) {
+			return fmt.Errorf("failed to delete doc %s for post %d after deleting %d: %w", docID, postID, deleted, err)
+		}
+		if err := sm.RemoveDocID(ctx, sc, postID); err != nil {
+			return err
+		}
+		deleted++
+	}
+	logger.Log.Infof("Deleted %d documents of site %s from dataset %s", deleted, sc.SiteID, sc.DifyDatasetID)
+	return nil
+}
+
+// checkDatasetUnshared refuses to delete a dataset other sites still sync into, or
+// whose other users cannot be told because a site record does not load.
+func checkDatasetUnshared(ctx context.Context, sm *sites.Manager, sc *sites.SiteConfig) error {
+	users, err := sm.DatasetUsers(ctx, sc.DifyDatasetID)
+	if err != nil {
+		return fmt.Errorf("failed to check which sites use dataset %s: %w", sc.DifyDatasetID, err)
+	}
+	for _, other := range users {
+		if other != sc.SiteID {
+			return fmt.Errorf("dataset %s is also used by site %s; remove only this site's documents instead: %w",
+				sc.DifyDatasetID, other, ErrDatasetShared)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/feed"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sink"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/source"
+	"dify-wp-sync/internal/wpcom"
+)
+
+// NewSource returns the content source matching the site's source type. WordPress.com
+// sites are read from the REST API at wpcomAPIBase, normally the Manager's
+// WPComAPIBase; empty uses the public API.
+func NewSource(siteCfg *sites.SiteConfig, wpcomAPIBase string) (source.ContentSource, error) {
+	switch siteCfg.Source() {
+	case sites.SourceWPCom, sites.SourceSelfHosted:
+		return wpcom.NewSource(siteCfg, wpcomAPIBase), nil
+	case sites.SourceFeed:
+		return feed.NewSource(siteCfg), nil
+	case sites.SourceWXR:
+		return nil, fmt.Errorf("site %s is fed from WXR exports; use import-wxr instead", siteCfg.SiteID)
+	default:
+		return nil, fmt.Errorf("unknown source type %q for site %s", siteCfg.SourceType, siteCfg.SiteID)
+	}
+}
+
+// SyncSite syncs a registered site into its Dify dataset using the site's own source.
+func SyncSite(ctx context.Context, sm *sites.Manager, siteCfg *sites.SiteConfig, difyClient *dify.DifyClient, sinks ...sink.Sink) error {
+	src, err := NewSource(siteCfg, sm.WPComAPIBase)
+	if err != nil {
+		return err
+	}
+	return Run(ctx, sm, siteCfg, src, difyClient, sinks...)
+}
+
+// Run fetches items updated since the site's last sync and either creates or updates
+// corresponding documents in the Dify dataset. Every document is also written to the
+// given sinks. A nil difyClient writes to the sinks only. Documents of deleted items
+// are only removed by syncs of registered sites; see RunOptions.Prune. Mapping changes
+// are persisted through sm as they happen, which may be nil when difyClient is; the
+// caller is responsible for persisting siteCfg afterwards.
+func Run(ctx context.Context, sm *sites.Manager, siteCfg *sites.SiteConfig, src source.ContentSource, difyClient *dify.DifyClient, sinks ...sink.Sink) error {
+	if siteCfg.PostDocMapping == nil {
+		siteCfg.PostDocMapping = make(map[int]string)
+	}
+	datasetID := siteCfg.DifyDatasetID
+	defer flushSinks(ctx, siteCfg.SiteID, sinks...)
+
+	updatedSyncTime, err := syncChanged(ctx, sm, siteCfg, src, difyClient, sinks...)
+	if err != nil {
+		return err
+	}
+	if siteCfg.DifyDatasetID != datasetID {
+		// The dataset was found missing and recreated partway through, so items synced
+		// before that went into the old one. Go over everything again.
+		logger.Log.Infof("Syncing all items of site %s into its new dataset %s", siteCfg.SiteID, siteCfg.DifyDatasetID)
+		if updatedSyncTime, err = syncChanged(ctx, sm, siteCfg, src, difyClient, sinks...); err != nil {
+			return err
+		}
+	}
+
+	siteCfg.LastSyncTime = updatedSyncTime
+	return nil
+}
+
+// syncChanged syncs the items changed since the site's last sync time and returns
+// the newest modification time among those synced.
+func syncChanged(ctx context.Context, sm *sites.Manager, siteCfg *sites.SiteConfig, src source.ContentSource, difyClient *dify.DifyClient, sinks ...sink.Sink) (time.Time, error) {
+	updatedSyncTime := siteCfg.LastSyncTime
+	err := src.ListChanged(ctx, siteCfg.LastSyncTime, func(items []source.Item) error {
+		for _, it := range items {
+			if it.Content == "" {
+				logger.Log.Warnf("Post %d (%s) has empty content, skipping creation/update", it.ID, it.Title)
+				continue
+			}
+			if err := SyncItem(ctx, sm, siteCfg, it, difyClient, sinks...); err != nil {
+				logger.Log.Errorf("%v", err)
+				continue
+			}
+			if obs, ok := src.(source.SyncObserver); ok {
+				obs.ItemSynced(it)
+			}
+			if it.Modified.After(updatedSyncTime) {
+				updatedSyncTime = it.Modified
+			}
+		}
+		return nil
+	})
+	return updatedSyncTime, err
+}
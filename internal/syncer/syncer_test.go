@@ -0,0 +1,188 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"dify-wp-sync/internal/dify"
+	"dify-wp-sync/internal/difytest"
+	"dify-wp-sync/internal/localstore"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/wpcom"
+	"dify-wp-sync/internal/wpcomtest"
+)
+
+// testEnv is a registered WordPress.com site syncing from a fake WordPress.com into
+// a fake Dify, with its state in an in-memory store.
+type testEnv struct {
+	wp   *wpcomtest.Server
+	dify *difytest.Server
+	sm   *sites.Manager
+	dc   *dify.DifyClient
+	site *sites.SiteConfig
+}
+
+const testSiteID = "1001"
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	wp := wpcomtest.NewServer()
+	t.Cleanup(wp.Close)
+	df := difytest.NewServer()
+	t.Cleanup(df.Close)
+
+	sm := sites.NewManager(localstore.NewMemory(), nil)
+	sm.WPComAPIBase = wp.APIBase()
+	env := &testEnv{
+		wp:   wp,
+		dify: df,
+		sm:   sm,
+		dc:   dify.NewDifyClient(difytest.APIKey, df.BaseURL(), dify.WithRetry(4, time.Millisecond)),
+	}
+
+	wp.AddSite(testSiteID, "https://example.wordpress.com")
+	env.site = env.addSite(t, testSiteID, df.AddDataset("https://example.wordpress.com"))
+	return env
+}
+
+// addSite registers siteID with a token for the fake WordPress.com and returns its
+// stored config.
+func (e *testEnv) addSite(t *testing.T, siteID, datasetID string) *sites.SiteConfig {
+	t.Helper()
+	ctx := context.Background()
+	err := e.sm.AddSite(ctx, &sites.SiteConfig{
+		SiteID:        siteID,
+		BlogURL:       "https://example.wordpress.com",
+		AccessToken:   e.wp.IssueToken(siteID),
+		DifyDatasetID: datasetID,
+		Status:        sites.StatusActive,
+	})
+	if err != nil {
+		t.Fatalf("AddSite: %v", err)
+	}
+	sc, err := e.sm.GetSite(ctx, siteID)
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	return sc
+}
+
+// post adds or replaces a post of the test site, modified at the given offset from a
+// fixed time so successive changes sort after each other.
+func (e *testEnv) post(id int, title, content string, modified time.Duration) {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Add(modified)
+	e.wp.AddPost(testSiteID, wpcom.Post{ID: id, Title: title, Content: content, Modified: at.Format(time.RFC3339)})
+}
+
+// syncRegistered runs a sync of the test site the way the CLI and scheduler do and
+// returns the site as stored afterwards.
+func (e *testEnv) syncRegistered(t *testing.T) *sites.SiteConfig {
+	t.Helper()
+	ctx := context.Background()
+	if err := SyncRegisteredSite(ctx, e.sm, e.dc, testSiteID, RunOptions{Trigger: "test"}); err != nil {
+		t.Fatalf("SyncRegisteredSite: %v", err)
+	}
+	sc, err := e.sm.GetSite(ctx, testSiteID)
+	if err != nil {
+		t.Fatalf("GetSite: %v", err)
+	}
+	return sc
+}
+
+// countRequests returns how many requests the fake Dify got whose "METHOD URI"
+// contains substr.
+func (e *testEnv) countRequests(substr string) int {
+	n := 0
+	for _, r := range e.dify.Requests() {
+		if strings.Contains(r, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSyncSiteCreatesThenUpdatesDocuments(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.post(1, "First", "<p>first version</p>", 0)
+	env.post(2, "Second", "<p>second post</p>", time.Hour)
+
+	if err := SyncSite(ctx, env.sm, env.site, env.dc); err != nil {
+		t.Fatalf("SyncSite: %v", err)
+	}
+	docs := env.dify.Documents(env.site.DifyDatasetID)
+	if len(docs) != 2 {
+		t.Fatalf("got %d documents after the first sync, want 2", len(docs))
+	}
+	stored, err := env.sm.GetPostDocMapping(ctx, testSiteID)
+	if err != nil {
+		t.Fatalf("GetPostDocMapping: %v", err)
+	}
+	for _, postID := range []int{1, 2} {
+		doc, ok := env.dify.Document(env.site.DifyDatasetID, stored[postID])
+		if !ok {
+			t.Fatalf("post %d is mapped to %q, which does not exist", postID, stored[postID])
+		}
+		if _, gotPost, _ := ParseDocumentName(doc.Name); gotPost != postID {
+			t.Errorf("document %s is named %q, want a name for post %d", doc.ID, doc.Name, postID)
+		}
+	}
+	if want := time.Date(2026, 1, 1, 1, 0, 0, 0, time.UTC); !env.site.LastSyncTime.Equal(want) {
+		t.Errorf("LastSyncTime = %s, want %s", env.site.LastSyncTime, want)
+	}
+
+	env.post(1, "First", "<p>second version</p>", 2*time.Hour)
+	if err := SyncSite(ctx, env.sm, env.site, env.dc); err != nil {
+		t.Fatalf("SyncSite: %v", err)
+	}
+	if docs := env.dify.Documents(env.site.DifyDatasetID); len(docs) != 2 {
+		t.Fatalf("got %d documents after the update, want 2", len(docs))
+	}
+	doc, _ := env.dify.Document(env.site.DifyDatasetID, stored[1])
+	if doc.Updates != 1 || !strings.Contains(doc.Text, "second version") {
+		t.Errorf("document of post 1 has %d updates and text %q, want 1 update with the new content", doc.Updates, doc.Text)
+	}
+	if doc, _ := env.dify.Document(env.site.DifyDatasetID, stored[2]); doc.Updates != 0 {
+		t.Errorf("unchanged post 2 was updated %d times", doc.Updates)
+	}
+}
+
+func TestSyncSiteRetriesThrottledCreates(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	env.post(1, "Throttled", "<p>content</p>", 0)
+	env.dify.Inject(difytest.Fault{Method: "POST", Path: "/document/create", Status: 429, RetryAfter: "0", Times: 2})
+
+	if err := SyncSite(ctx, env.sm, env.site, env.dc); err != nil {
+		t.Fatalf("SyncSite: %v", err)
+	}
+	if got := env.countRequests("/document/create"); got != 3 {
+		t.Errorf("sent %d create requests, want 3 (two throttled, one accepted)", got)
+	}
+	docs := env.dify.Documents(env.site.DifyDatasetID)
+	if len(docs) != 1 || env.site.PostDocMapping[1] != docs[0].ID {
+		t.Fatalf("got documents %v and mapping %v, want one document mapped to post 1", docs, env.site.PostDocMapping)
+	}
+}
+
+func TestSyncRegisteredSitePersistsState(t *testing.T) {
+	env := newTestEnv(t)
+	for i := 1; i <= 3; i++ {
+		env.post(i, fmt.Sprintf("Post %d", i), "<p>content</p>", time.Duration(i)*time.Minute)
+	}
+
+	sc := env.syncRegistered(t)
+	if len(sc.PostDocMapping) != 3 {
+		t.Errorf("stored mapping has %d entries, want 3", len(sc.PostDocMapping))
+	}
+	if want := time.Date(2026, 1, 1, 0, 3, 0, 0, time.UTC); !sc.LastSyncTime.Equal(want) {
+		t.Errorf("stored LastSyncTime = %s, want %s", sc.LastSyncTime, want)
+	}
+	runs, err := env.sm.ListSyncRuns(context.Background(), testSiteID, 10)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("ListSyncRuns = %v, %v; want one run", runs, err)
+	}
+}
@@ -0,0 +1,173 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"dify-wp-sync/internal/jobs"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/storage"
+)
+
+const (
+	// SignatureHeader carries "sha256=<hex HMAC of the raw body>" keyed with the site's webhook secret.
+	SignatureHeader = "X-Dify-WP-Sync-Signature"
+
+	maxBodyBytes = 1 << 20
+	deliveryTTL  = 24 * time.Hour
+)
+
+// Actions a notification can carry.
+const (
+	ActionPublish = "publish"
+	ActionUpdate  = "update"
+	ActionTrash   = "trash"
+)
+
+// Notification is the JSON body a WordPress site (Jetpack or a small plugin) posts
+// when a post is published, updated or trashed.
+type Notification struct {
+	SiteID     string    `json:"site_id"`
+	PostID     int       `json:"post_id"`
+	Action     string    `json:"action"`
+	Modified   time.Time `json:"modified"`              // post_modified_gmt after the change, used for ordering
+	DeliveryID string    `json:"delivery_id,omitempty"` // unique per delivery attempt, used for de-duplication
+}
+
+// Handler verifies webhook notifications and queues a post job for each one (see
+// syncer.SyncPost). With the Redis job queue, accepted notifications survive restarts.
+type Handler struct {
+	SitesMgr *sites.Manager
+	Store    storage.Store
+	Jobs     jobs.Queue
+}
+
+// NewHandler creates a Handler that queues notifications on queue.
+func NewHandler(sitesMgr *sites.Manager, store storage.Store, queue jobs.Queue) *Handler {
+	return &Handler{SitesMgr: sitesMgr, Store: store, Jobs: queue}
+}
+
+func deliveryKey(siteID, deliveryID string) string {
+	return fmt.Sprintf("wp_webhook_delivery:%s:%s", siteID, deliveryID)
+}
+
+// HandleWPCom accepts a notification, checks its signature against the site's secret,
+// drops duplicates and stale deliveries, and queues the rest. A delivery ID is only
+// kept once its notification is queued, so a retry after a failure is not taken for
+// a duplicate.
+func (h *Handler) HandleWPCom(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	var n Notification
+	if err := json.Unmarshal(body, &n); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if n.SiteID == "" || n.PostID == 0 || n.Modified.IsZero() {
+		http.Error(w, "site_id, post_id and modified are required", http.StatusBadRequest)
+		return
+	}
+	if n.Action != ActionPublish && n.Action != ActionUpdate && n.Action != ActionTrash {
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+
+	sc, err := h.SitesMgr.GetSite(ctx, n.SiteID)
+	if err != nil && !errors.Is(err, sites.ErrSiteNotFound) {
+		logger.Log.Errorf("Failed to load site %s for webhook: %v", n.SiteID, err)
+		http.Error(w, "Temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil || sc.WebhookSecret == "" {
+		// Unknown sites and sites without a secret look the same to avoid leaking which exist.
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if !validSignature(sc.WebhookSecret, body, r.Header.Get(SignatureHeader)) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	if n.DeliveryID != "" {
+		first, err := h.Store.SetNX(ctx, deliveryKey(n.SiteID, n.DeliveryID), 1, deliveryTTL)
+		if err != nil {
+			logger.Log.Errorf("Failed to record webhook delivery %s: %v", n.DeliveryID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !first {
+			logger.Log.Infof("Ignoring duplicate webhook delivery %s for site %s", n.DeliveryID, n.SiteID)
+			fmt.Fprint(w, "duplicate")
+			return
+		}
+	}
+
+	// The authoritative check is made by the job under the site's lock; this one
+	// only saves queueing notifications that are already known to be stale.
+	applied, err := h.SitesMgr.PostVersion(ctx, n.SiteID, n.PostID)
+	if err != nil {
+		logger.Log.Errorf("Failed to load webhook version for post %d on site %s: %v", n.PostID, n.SiteID, err)
+		h.forgetDelivery(n)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !n.Modified.After(applied) {
+		logger.Log.Infof("Ignoring stale webhook for post %d on site %s (modified %s, already applied %s)",
+			n.PostID, n.SiteID, n.Modified, applied)
+		fmt.Fprint(w, "stale")
+		return
+	}
+
+	job, err := h.Jobs.EnqueuePost(ctx, n.SiteID, n.PostID, n.Modified, n.Action == ActionTrash)
+	if err != nil {
+		logger.Log.Errorf("Failed to queue webhook %s for post %d on site %s: %v", n.Action, n.PostID, n.SiteID, err)
+		h.forgetDelivery(n)
+		http.Error(w, "Temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	logger.Log.Infof("Queued job %s for webhook %s of post %d on site %s", job.ID, n.Action, n.PostID, n.SiteID)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, "queued")
+}
+
+// forgetDelivery releases a delivery ID whose notification was not queued, so the
+// sender's retry is accepted.
+func (h *Handler) forgetDelivery(n Notification) {
+	if n.DeliveryID == "" {
+		return
+	}
+	if err := h.Store.Del(context.Background(), deliveryKey(n.SiteID, n.DeliveryID)); err != nil {
+		logger.Log.Warnf("Failed to release webhook delivery %s: %v", n.DeliveryID, err)
+	}
+}
+
+// validSignature checks a "sha256=<hex>" header against the HMAC of body.
+func validSignature(secret string, body []byte, header string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dify-wp-sync/internal/jobs"
+	"dify-wp-sync/internal/localstore"
+	"dify-wp-sync/internal/sites"
+)
+
+const (
+	testSiteID = "1001"
+	testSecret = "hook-secret"
+)
+
+// recordingQueue records the post jobs it is asked to queue.
+type recordingQueue struct {
+	posts []int
+}
+
+func (q *recordingQueue) Enqueue(ctx context.Context, siteID, kind string) (*jobs.Job, error) {
+	return nil, fmt.Errorf("unexpected %s job for site %s", kind, siteID)
+}
+
+func (q *recordingQueue) EnqueuePost(ctx context.Context, siteID string, postID int, modified time.Time, trash bool) (*jobs.Job, error) {
+	q.posts = append(q.posts, postID)
+	return &jobs.Job{ID: fmt.Sprintf("job-%d", len(q.posts)), SiteID: siteID}, nil
+}
+
+func (q *recordingQueue) Get(ctx context.Context, jobID string) (*jobs.Job, error) {
+	return nil, jobs.ErrNotFound
+}
+
+func newTestHandler(t *testing.T) (*Handler, *recordingQueue) {
+	t.Helper()
+	store := localstore.NewMemory()
+	sm := sites.NewManager(store, nil)
+	err := sm.AddSite(context.Background(), &sites.SiteConfig{SiteID: testSiteID, WebhookSecret: testSecret, Status: sites.StatusActive})
+	if err != nil {
+		t.Fatalf("AddSite: %v", err)
+	}
+	q := &recordingQueue{}
+	return NewHandler(sm, store, q), q
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func notification(postID int, modified time.Time, deliveryID string) string {
+	return fmt.Sprintf(`{"site_id":%q,"post_id":%d,"action":"update","modified":%q,"delivery_id":%q}`,
+		testSiteID, postID, modified.Format(time.RFC3339), deliveryID)
+}
+
+func deliver(h *Handler, body, signature string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/webhooks/wpcom", strings.NewReader(body))
+	if signature != "" {
+		r.Header.Set(SignatureHeader, signature)
+	}
+	w := httptest.NewRecorder()
+	h.HandleWPCom(w, r)
+	return w
+}
+
+func TestWebhookRejectsBadSignatures(t *testing.T) {
+	h, q := newTestHandler(t)
+	body := notification(1, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), "d1")
+	unknownSite := strings.Replace(body, testSiteID, "2002", 1)
+
+	for name, tc := range map[string]struct{ body, signature string }{
+		"missing":      {body, ""},
+		"wrong secret": {body, sign("other-secret", body)},
+		"other body":   {body, sign(testSecret, notification(2, time.Now(), "d1"))},
+		"not hex":      {body, "sha256=not-hex"},
+		"unknown site": {unknownSite, sign(testSecret, unknownSite)},
+	} {
+		if w := deliver(h, tc.body, tc.signature); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s signature: status %d, want 401", name, w.Code)
+		}
+	}
+	if len(q.posts) != 0 {
+		t.Errorf("queued posts %v for unsigned notifications", q.posts)
+	}
+
+	if w := deliver(h, body, sign(testSecret, body)); w.Code != http.StatusAccepted {
+		t.Fatalf("signed notification: status %d, want 202", w.Code)
+	}
+	if len(q.posts) != 1 || q.posts[0] != 1 {
+		t.Errorf("queued posts %v, want [1]", q.posts)
+	}
+}
+
+func TestWebhookDropsDuplicateAndStaleDeliveries(t *testing.T) {
+	h, q := newTestHandler(t)
+	ctx := context.Background()
+	applied := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := h.SitesMgr.SetPostVersion(ctx, testSiteID, 1, applied); err != nil {
+		t.Fatalf("SetPostVersion: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		modified time.Time
+		delivery string
+		want     string
+	}{
+		{"older than applied", applied.Add(-time.Hour), "d1", "stale"},
+		{"same as applied", applied, "d2", "stale"},
+		{"newer", applied.Add(time.Hour), "d3", "queued"},
+		{"redelivered", applied.Add(time.Hour), "d3", "duplicate"},
+	} {
+		body := notification(1, tc.modified, tc.delivery)
+		w := deliver(h, body, sign(testSecret, body))
+		if got := w.Body.String(); got != tc.want {
+			t.Errorf("%s: response %q (status %d), want %q", tc.name, got, w.Code, tc.want)
+		}
+	}
+	if len(q.posts) != 1 {
+		t.Errorf("queued %d jobs, want only the newer change", len(q.posts))
+	}
+}
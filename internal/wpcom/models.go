@@ -1,10 +1,8 @@
 package wpcom
 
 import (
-	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/source"
 	"time"
-
-	md "github.com/JohannesKaufmann/html-to-markdown"
 )
 
 // Post represents a WordPress.com post or page.
@@ -15,6 +13,8 @@ type Post struct {
 	Title    string `json:"title"`
 	Content  string `json:"content"`
 	Type     string `json:"type"`
+	Status   string `json:"status"`
+	URL      string `json:"URL"`
 }
 
 func (p Post) ModifiedTime() time.Time {
@@ -22,15 +22,16 @@ func (p Post) ModifiedTime() time.Time {
 	return t
 }
 
-func (p Post) GetMarkdownContent() string {
-	converter := md.NewConverter("", true, nil)
-	markdown, err := converter.ConvertString(p.Content)
-	if err != nil {
-		// Log error but return original content as fallback
-		logger.Log.Errorf("Failed to convert HTML to Markdown for post %d: %v", p.ID, err)
-		return p.Content
+// ToItem converts the post into the source-agnostic shape used by the sync engine.
+func (p Post) ToItem() source.Item {
+	return source.Item{
+		ID:       p.ID,
+		Title:    p.Title,
+		Content:  p.Content,
+		Type:     p.Type,
+		URL:      p.URL,
+		Modified: p.ModifiedTime(),
 	}
-	return markdown
 }
 
 // PostsResponse represents the WordPress.com API response for posts.
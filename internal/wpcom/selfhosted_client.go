@@ -0,0 +1,247 @@
+package wpcom
+
+import (
+	"context"
+	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/source"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SelfHostedClient interacts with a self-hosted WordPress site through the
+// WP REST API v2, authenticating with an application password.
+type SelfHostedClient struct {
+	BaseURL     string
+	Username    string
+	AppPassword string
+	httpClient  *http.Client
+}
+
+// NewSelfHostedClient creates a new SelfHostedClient for the site at baseURL.
+func NewSelfHostedClient(baseURL, username, appPassword string) *SelfHostedClient {
+	return &SelfHostedClient{
+		BaseURL:     strings.TrimRight(baseURL, "/"),
+		Username:    username,
+		AppPassword: appPassword,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// restPost is the subset of the WP REST API v2 post object we care about.
+type restPost struct {
+	ID          int    `json:"id"`
+	DateGMT     string `json:"date_gmt"`
+	ModifiedGMT string `json:"modified_gmt"`
+	Type        string `json:"type"`
+	Status      string `json:"status"`
+	Link        string `json:"link"`
+	Title       struct {
+		Rendered string `json:"rendered"`
+	} `json:"title"`
+	Content struct {
+		Rendered string `json:"rendered"`
+	} `json:"content"`
+}
+
+// toPost converts a REST API v2 post into the WordPress.com Post shape used by the sync.
+func (rp restPost) toPost() Post {
+	return Post{
+		ID:       rp.ID,
+		Date:     gmtToRFC3339(rp.DateGMT),
+		Modified: gmtToRFC3339(rp.ModifiedGMT),
+		Title:    html.UnescapeString(rp.Title.Rendered),
+		Content:  rp.Content.Rendered,
+		Type:     rp.Type,
+		Status:   rp.Status,
+		URL:      rp.Link,
+	}
+}
+
+// gmtToRFC3339 converts the zone-less "*_gmt" timestamps returned by the REST API to RFC3339.
+func gmtToRFC3339(s string) string {
+	t, err := time.Parse("2006-01-02T15:04:05", s)
+	if err != nil {
+		return s
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// restBase maps a post type to its REST API collection. Core types use plural
+// routes; custom post types default to their own name.
+func restBase(postType string) string {
+	switch postType {
+	case "post":
+		return "posts"
+	case "page":
+		return "pages"
+	default:
+		return postType
+	}
+}
+
+const restFields = "id,date_gmt,modified_gmt,type,status,link,title,content"
+
+func (c *SelfHostedClient) newRequest(ctx context.Context, path string, params url.Values) (*http.Request, error) {
+	apiURL := c.BaseURL + "/wp-json/wp/v2/" + path
+	if len(params) > 0 {
+		apiURL += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.Username, c.AppPassword)
+	req.Header.Set("User-Agent", "Dify-WP-Sync/1.0")
+	return req, nil
+}
+
+// VerifyCredentials checks that the application password is accepted by the site.
+func (c *SelfHostedClient) VerifyCredentials(ctx context.Context) error {
+	req, err := c.newRequest(ctx, "users/me", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return statusError(resp.StatusCode, body, "WordPress REST API")
+	}
+	return nil
+}
+
+// GetPostsBatch fetches one page of published posts of postType ordered by modification date,
+// returning those modified after modifiedAfter and whether more pages remain.
+func (c *SelfHostedClient) GetPostsBatch(ctx context.Context, modifiedAfter time.Time, postType string, offset, limit int) ([]Post, bool, error) {
+	logger.Log.Infof("Fetching batch of type '%s' from self-hosted site %s (offset: %d, limit: %d)",
+		postType, c.BaseURL, offset, limit)
+
+	params := url.Values{}
+	params.Set("per_page", strconv.Itoa(limit))
+	params.Set("offset", strconv.Itoa(offset))
+	params.Set("orderby", "modified")
+	params.Set("order", "desc")
+	params.Set("status", "publish")
+	params.Set("_fields", restFields)
+	if !modifiedAfter.IsZero() {
+		params.Set("modified_after", modifiedAfter.UTC().Format(time.RFC3339))
+	}
+
+	req, err := c.newRequest(ctx, restBase(postType), params)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	logger.Log.Infof("Received response status: %d from WordPress REST API", resp.StatusCode)
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, statusError(resp.StatusCode, bodyBytes, "WordPress REST API")
+	}
+
+	var response []restPost
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, false, fmt.Errorf("failed to decode API response: %v", err)
+	}
+
+	total, _ := strconv.Atoi(resp.Header.Get("X-WP-Total"))
+	logger.Log.Infof("Batch stats - Found: %d, Offset: %d, Limit: %d, Posts in response: %d",
+		total, offset, limit, len(response))
+
+	var matchingPosts []Post
+	for _, rp := range response {
+		p := rp.toPost()
+		if p.ModifiedTime().After(modifiedAfter) {
+			matchingPosts = append(matchingPosts, p)
+		}
+	}
+
+	hasMore := offset+limit < total
+	return matchingPosts, hasMore, nil
+}
+
+// getJSON performs an authenticated GET request and decodes the JSON response into dest,
+// mapping 404 to source.ErrNotFound. It returns the response headers for pagination.
+func (c *SelfHostedClient) getJSON(ctx context.Context, path string, params url.Values, dest interface{}) (http.Header, error) {
+	req, err := c.newRequest(ctx, path, params)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, source.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, statusError(resp.StatusCode, body, "WordPress REST API")
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
+		return nil, fmt.Errorf("failed to decode API response: %v", err)
+	}
+	return resp.Header, nil
+}
+
+// GetPost fetches a single post of postType by ID.
+func (c *SelfHostedClient) GetPost(ctx context.Context, postType string, postID int) (*Post, error) {
+	params := url.Values{}
+	params.Set("_fields", restFields)
+
+	var rp restPost
+	if _, err := c.getJSON(ctx, fmt.Sprintf("%s/%d", restBase(postType), postID), params, &rp); err != nil {
+		return nil, err
+	}
+	p := rp.toPost()
+	return &p, nil
+}
+
+// ListPostIDs returns the IDs of all published posts of postType.
+func (c *SelfHostedClient) ListPostIDs(ctx context.Context, postType string) ([]int, error) {
+	var ids []int
+	for page := 1; ; page++ {
+		params := url.Values{}
+		params.Set("per_page", "100")
+		params.Set("page", strconv.Itoa(page))
+		params.Set("status", "publish")
+		params.Set("_fields", "id")
+
+		var response []restPost
+		header, err := c.getJSON(ctx, restBase(postType), params, &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, rp := range response {
+			ids = append(ids, rp.ID)
+		}
+		totalPages, _ := strconv.Atoi(header.Get("X-WP-TotalPages"))
+		if len(response) == 0 || page >= totalPages {
+			break
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,111 @@
+package wpcom
+
+import (
+	"context"
+	"dify-wp-sync/internal/sites"
+	"dify-wp-sync/internal/source"
+	"errors"
+	"time"
+)
+
+// postAPI is implemented by every WordPress API client a Source can read from.
+type postAPI interface {
+	GetPostsBatch(ctx context.Context, modifiedAfter time.Time, postType string, offset, limit int) ([]Post, bool, error)
+	GetPost(ctx context.Context, postType string, postID int) (*Post, error)
+	ListPostIDs(ctx context.Context, postType string) ([]int, error)
+}
+
+// Source adapts a WordPress API client to source.ContentSource, restricted to
+// the site's configured post types.
+type Source struct {
+	api       postAPI
+	postTypes []string
+}
+
+// NewSource returns a content source for a WordPress.com or self-hosted site.
+// WordPress.com sites are read from the REST API at apiBase, or DefaultAPIBase if it
+// is empty.
+func NewSource(siteCfg *sites.SiteConfig, apiBase string) *Source {
+	var api postAPI
+	if siteCfg.Source() == sites.SourceSelfHosted {
+		api = NewSelfHostedClient(siteCfg.BlogURL, siteCfg.Username, siteCfg.AccessToken)
+	} else {
+		api = NewWPClient(siteCfg.AccessToken, siteCfg.SiteID, WithAPIBase(apiBase))
+	}
+
+	postTypes := siteCfg.PostTypes
+	if len(postTypes) == 0 {
+		postTypes = []string{"post"}
+	}
+	return &Source{api: api, postTypes: postTypes}
+}
+
+// ListChanged pages through each post type and passes posts modified after since to fn.
+func (s *Source) ListChanged(ctx context.Context, since time.Time, fn func(items []source.Item) error) error {
+	for _, postType := range s.postTypes {
+		offset := 0
+		limit := 100
+
+		for {
+			posts, hasMore, err := s.api.GetPostsBatch(ctx, since, postType, offset, limit)
+			if err != nil {
+				return err
+			}
+
+			items := make([]source.Item, 0, len(posts))
+			for _, p := range posts {
+				items = append(items, p.ToItem())
+			}
+			if err := fn(items); err != nil {
+				return err
+			}
+
+			if !hasMore {
+				break
+			}
+			offset += limit
+		}
+	}
+	return nil
+}
+
+// GetItem fetches a single published post of one of the configured types.
+func (s *Source) GetItem(ctx context.Context, id int) (*source.Item, error) {
+	for _, postType := range s.postTypes {
+		p, err := s.api.GetPost(ctx, postType, id)
+		if errors.Is(err, source.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p.Status != "publish" || !s.hasType(p.Type) {
+			return nil, source.ErrNotFound
+		}
+		item := p.ToItem()
+		return &item, nil
+	}
+	return nil, source.ErrNotFound
+}
+
+// ListIDs returns the IDs of all published posts of the configured types.
+func (s *Source) ListIDs(ctx context.Context) ([]int, error) {
+	var ids []int
+	for _, postType := range s.postTypes {
+		typeIDs, err := s.api.ListPostIDs(ctx, postType)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, typeIDs...)
+	}
+	return ids, nil
+}
+
+func (s *Source) hasType(postType string) bool {
+	for _, t := range s.postTypes {
+		if t == postType {
+			return true
+		}
+	}
+	return false
+}
@@ -1,30 +1,71 @@
 package wpcom
 
 import (
+	"context"
 	"dify-wp-sync/internal/logger"
+	"dify-wp-sync/internal/source"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// The WordPress.com REST API and OAuth roots, used unless WPCOM_API_BASE and
+// WPCOM_OAUTH_BASE point somewhere else, such as a proxy or wpcomtest.
+const (
+	DefaultAPIBase   = "https://public-api.wordpress.com/rest/v1.1"
+	DefaultOAuthBase = "https://public-api.wordpress.com/oauth2"
+)
+
 // WPClient interacts with the WordPress.com API.
 type WPClient struct {
 	AccessToken string
 	SiteID      string
+	apiBase     string
 	httpClient  *http.Client
 }
 
+// ClientOption customizes a WPClient.
+type ClientOption func(*WPClient)
+
+// WithAPIBase makes the client use the REST API at apiBase instead of DefaultAPIBase.
+// An empty apiBase keeps the default.
+func WithAPIBase(apiBase string) ClientOption {
+	return func(c *WPClient) {
+		if apiBase != "" {
+			c.apiBase = strings.TrimRight(apiBase, "/")
+		}
+	}
+}
+
+// WithHTTPClient makes the client send its requests through httpClient.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *WPClient) {
+		c.httpClient = httpClient
+	}
+}
+
 // NewWPClient creates a new WPClient with the provided token, site ID, and a default timeout.
-func NewWPClient(token, siteID string) *WPClient {
-	return &WPClient{
+func NewWPClient(token, siteID string, opts ...ClientOption) *WPClient {
+	c := &WPClient{
 		AccessToken: token,
 		SiteID:      siteID,
+		apiBase:     DefaultAPIBase,
 		httpClient:  &http.Client{Timeout: 30 * time.Second},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// postsURL returns the URL of the site's posts collection.
+func (c *WPClient) postsURL() string {
+	return fmt.Sprintf("%s/sites/%s/posts", c.apiBase, c.SiteID)
 }
 
 // GetPosts fetches posts of specified types updated since modifiedAfter.
@@ -40,7 +81,7 @@ func (c *WPClient) GetPosts(modifiedAfter time.Time, postTypes []string) ([]Post
 		limit := 100
 
 		for {
-			apiURL := fmt.Sprintf("https://public-api.wordpress.com/rest/v1.1/sites/%s/posts", c.SiteID)
+			apiURL := c.postsURL()
 			params := url.Values{}
 			params.Set("number", strconv.Itoa(limit))  // how many items to fetch per request
 			params.Set("offset", strconv.Itoa(offset)) // how many items to skip
@@ -72,7 +113,7 @@ func (c *WPClient) GetPosts(modifiedAfter time.Time, postTypes []string) ([]Post
 
 			if resp.StatusCode != http.StatusOK {
 				logger.Log.Errorf("Non-200 response: %d, body: %s", resp.StatusCode, string(bodyBytes))
-				return nil, fmt.Errorf("unexpected status code %d from WordPress API", resp.StatusCode)
+				return nil, statusError(resp.StatusCode, bodyBytes, "WordPress API")
 			}
 
 			var response PostsResponse
@@ -122,20 +163,22 @@ func (c *WPClient) GetPosts(modifiedAfter time.Time, postTypes []string) ([]Post
 	return allPosts, nil
 }
 
-func (c *WPClient) GetPostsBatch(modifiedAfter time.Time, postType string, offset, limit int) ([]Post, bool, error) {
+// GetPostsBatch fetches one page of posts of postType ordered by modification date,
+// returning those modified after modifiedAfter and whether more pages remain.
+func (c *WPClient) GetPostsBatch(ctx context.Context, modifiedAfter time.Time, postType string, offset, limit int) ([]Post, bool, error) {
 	logger.Log.Infof("Fetching batch of type '%s' from site %s (offset: %d, limit: %d)",
 		postType, c.SiteID, offset, limit)
 
-	apiURL := fmt.Sprintf("https://public-api.wordpress.com/rest/v1.1/sites/%s/posts", c.SiteID)
+	apiURL := c.postsURL()
 	params := url.Values{}
 	params.Set("number", strconv.Itoa(limit))
 	params.Set("offset", strconv.Itoa(offset))
 	params.Set("order_by", "modified")
 	params.Set("order", "DESC")
-	params.Set("fields", "ID,date,modified,title,content,type")
+	params.Set("fields", "ID,date,modified,title,content,type,status,URL")
 	params.Set("type", postType)
 
-	req, err := http.NewRequest("GET", apiURL+"?"+params.Encode(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL+"?"+params.Encode(), nil)
 	if err != nil {
 		return nil, false, err
 	}
@@ -156,7 +199,7 @@ func (c *WPClient) GetPostsBatch(modifiedAfter time.Time, postType string, offse
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, false, fmt.Errorf("unexpected status code %d from WordPress API", resp.StatusCode)
+		return nil, false, statusError(resp.StatusCode, bodyBytes, "WordPress API")
 	}
 
 	var response PostsResponse
@@ -177,3 +220,111 @@ func (c *WPClient) GetPostsBatch(modifiedAfter time.Time, postType string, offse
 	hasMore := offset+limit < response.Found
 	return matchingPosts, hasMore, nil
 }
+
+// get performs an authenticated GET request and returns the response body,
+// mapping 404 to source.ErrNotFound.
+func (c *WPClient) get(ctx context.Context, apiURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	req.Header.Set("User-Agent", "Dify-WP-Sync/1.0")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, source.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp.StatusCode, bodyBytes, "WordPress API")
+	}
+	return bodyBytes, nil
+}
+
+// GetPost fetches a single post of any type by ID.
+func (c *WPClient) GetPost(ctx context.Context, postType string, postID int) (*Post, error) {
+	params := url.Values{}
+	params.Set("fields", "ID,date,modified,title,content,type,status,URL")
+	apiURL := fmt.Sprintf("%s/%d?%s", c.postsURL(), postID, params.Encode())
+
+	bodyBytes, err := c.get(ctx, apiURL)
+	if err != nil {
+		return nil, err
+	}
+	var p Post
+	if err := json.Unmarshal(bodyBytes, &p); err != nil {
+		return nil, fmt.Errorf("failed to decode API response: %v", err)
+	}
+	return &p, nil
+}
+
+// ListPostIDs returns the IDs of all published posts of postType.
+func (c *WPClient) ListPostIDs(ctx context.Context, postType string) ([]int, error) {
+	var ids []int
+	offset := 0
+	limit := 100
+
+	for {
+		params := url.Values{}
+		params.Set("number", strconv.Itoa(limit))
+		params.Set("offset", strconv.Itoa(offset))
+		params.Set("fields", "ID")
+		params.Set("type", postType)
+		apiURL := c.postsURL() + "?" + params.Encode()
+
+		bodyBytes, err := c.get(ctx, apiURL)
+		if err != nil {
+			return nil, err
+		}
+		var response PostsResponse
+		if err := json.Unmarshal(bodyBytes, &response); err != nil {
+			return nil, fmt.Errorf("failed to decode API response: %v", err)
+		}
+		for _, p := range response.Posts {
+			ids = append(ids, p.ID)
+		}
+		if len(response.Posts) == 0 || offset+limit >= response.Found {
+			break
+		}
+		offset += limit
+	}
+	return ids, nil
+}
+
+// statusError describes an unexpected response status from api, wrapping
+// source.ErrUnauthorized when the credentials were rejected: on 401, and on 403 only
+// when body carries one of WordPress.com's token error codes. Other 403s mean the
+// token is fine but lacks access to something, which reconnecting would not fix.
+func statusError(code int, body []byte, api string) error {
+	if code == http.StatusUnauthorized || (code == http.StatusForbidden && tokenRejected(body)) {
+		return fmt.Errorf("status code %d from %s: %w", code, api, source.ErrUnauthorized)
+	}
+	return fmt.Errorf("unexpected status code %d from %s", code, api)
+}
+
+// tokenRejected reports whether an error body names a missing or invalid token.
+// WordPress.com puts the code in "error", the WP REST API in "code".
+func tokenRejected(body []byte) bool {
+	var e struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if json.Unmarshal(body, &e) != nil {
+		return false
+	}
+	for _, c := range []string{e.Error, e.Code} {
+		if c == "authorization_required" || c == "invalid_token" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,502 @@
+// Package wpcomtest runs an in-process stand-in for the WordPress.com REST API and
+// OAuth endpoints, for end-to-end tests of the sync and connect flows. Point clients
+// at it with wpcom.WithAPIBase / oauth.WithAPIBase(s.APIBase()) and
+// oauth.WithOAuthBase(s.OAuthBase()); syncs read from it once a sites.Manager's
+// WPComAPIBase is set to s.APIBase().
+//
+// It serves posts (with type and status filters, ordering and offset pagination),
+// media, /me and /me/sites, and the authorize, token and token-info OAuth endpoints.
+// Every site belongs to the same user, UserID.
+package wpcomtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dify-wp-sync/internal/wpcom"
+)
+
+// UserID is the ID of the user owning every site.
+const UserID = 1
+
+// Client credentials the token endpoint accepts.
+const (
+	ClientID     = "wpcomtest-client"
+	ClientSecret = "wpcomtest-secret"
+)
+
+// Media is an item of a site's media library.
+type Media struct {
+	ID       int    `json:"ID"`
+	URL      string `json:"URL"`
+	Date     string `json:"date"`
+	MimeType string `json:"mime_type"`
+	Title    string `json:"title"`
+}
+
+type site struct {
+	url   string
+	name  string
+	posts map[int]wpcom.Post
+	media map[int]Media
+}
+
+// Server is a fake WordPress.com. Its zero value is not usable; create one with NewServer.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	sites    map[string]*site
+	tokens   map[string]string // token -> site ID, "" for global tokens
+	codes    map[string]string // authorization code -> site ID, "" for global tokens
+	requests []string
+	issued   int
+}
+
+// NewServer starts a fake WordPress.com without sites. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		sites:  make(map[string]*site),
+		tokens: make(map[string]string),
+		codes:  make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oauth2/authorize", s.handleAuthorize)
+	mux.HandleFunc("POST /oauth2/token", s.handleToken)
+	mux.HandleFunc("GET /oauth2/token-info", s.handleTokenInfo)
+	mux.HandleFunc("GET /rest/v1.1/me", s.handleMe)
+	mux.HandleFunc("GET /rest/v1.1/me/sites", s.handleMySites)
+	mux.HandleFunc("GET /rest/v1.1/sites/{site}/posts", s.handlePosts)
+	mux.HandleFunc("GET /rest/v1.1/sites/{site}/posts/{id}", s.handlePost)
+	mux.HandleFunc("GET /rest/v1.1/sites/{site}/media", s.handleMediaList)
+	mux.HandleFunc("GET /rest/v1.1/sites/{site}/media/{id}", s.handleMedia)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+		s.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// APIBase returns the REST API root, the counterpart of https://public-api.wordpress.com/rest/v1.1.
+func (s *Server) APIBase() string {
+	return s.URL + "/rest/v1.1"
+}
+
+// OAuthBase returns the OAuth root, the counterpart of https://public-api.wordpress.com/oauth2.
+func (s *Server) OAuthBase() string {
+	return s.URL + "/oauth2"
+}
+
+// AddSite adds a site with no posts or media.
+func (s *Server) AddSite(siteID, blogURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sites[siteID] = &site{
+		url:   blogURL,
+		name:  "Site " + siteID,
+		posts: make(map[int]wpcom.Post),
+		media: make(map[int]Media),
+	}
+}
+
+// AddPost adds or replaces a post. A missing type defaults to "post", status to
+// "publish", dates to now and URL to one below the site's URL.
+func (s *Server) AddPost(siteID string, p wpcom.Post) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.mustSite(siteID)
+	if p.Type == "" {
+		p.Type = "post"
+	}
+	if p.Status == "" {
+		p.Status = "publish"
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if p.Date == "" {
+		p.Date = now
+	}
+	if p.Modified == "" {
+		p.Modified = p.Date
+	}
+	if p.URL == "" {
+		p.URL = fmt.Sprintf("%s/?p=%d", strings.TrimRight(st.url, "/"), p.ID)
+	}
+	st.posts[p.ID] = p
+}
+
+// DeletePost removes a post, as if it was deleted permanently.
+func (s *Server) DeletePost(siteID string, postID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mustSite(siteID).posts, postID)
+}
+
+// AddMedia adds or replaces a media item.
+func (s *Server) AddMedia(siteID string, m Media) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mustSite(siteID).media[m.ID] = m
+}
+
+// IssueToken returns a new token for siteID, or a global token for every site if
+// siteID is "".
+func (s *Server) IssueToken(siteID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueLocked(siteID)
+}
+
+// Revoke makes token invalid, as if the user removed the app.
+func (s *Server) Revoke(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+}
+
+// AuthCode returns a single-use authorization code the token endpoint exchanges for
+// a token for siteID, or a global token if siteID is "".
+func (s *Server) AuthCode(siteID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.codeLocked(siteID)
+}
+
+// Requests returns every request served so far as "METHOD /path?query".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) mustSite(siteID string) *site {
+	st, ok := s.sites[siteID]
+	if !ok {
+		panic("wpcomtest: unknown site " + siteID)
+	}
+	return st
+}
+
+func (s *Server) issueLocked(siteID string) string {
+	s.issued++
+	token := fmt.Sprintf("token-%d", s.issued)
+	s.tokens[token] = siteID
+	return token
+}
+
+func (s *Server) codeLocked(siteID string) string {
+	s.issued++
+	code := fmt.Sprintf("code-%d", s.issued)
+	s.codes[code] = siteID
+	return code
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{"error": code, "message": message})
+}
+
+// handleAuthorize approves every request right away, redirecting back with a code.
+// The blog parameter picks the site; otherwise the first site by ID is used.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != ClientID {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid client_id or redirect_uri")
+		return
+	}
+
+	s.mu.Lock()
+	siteID := q.Get("blog")
+	if siteID == "" && q.Get("scope") != "global" {
+		ids := make([]string, 0, len(s.sites))
+		for id := range s.sites {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if len(ids) > 0 {
+			siteID = ids[0]
+		}
+	}
+	if q.Get("scope") == "global" {
+		siteID = ""
+	}
+	code := s.codeLocked(siteID)
+	s.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("client_id") != ClientID || r.PostForm.Get("client_secret") != ClientSecret {
+		writeError(w, http.StatusBadRequest, "invalid_client", "unknown client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := r.PostForm.Get("code")
+	siteID, ok := s.codes[code]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "unknown or used code")
+		return
+	}
+	delete(s.codes, code)
+
+	resp := map[string]string{"access_token": s.issueLocked(siteID), "token_type": "bearer"}
+	if siteID == "" {
+		resp["blog_id"] = "0"
+		resp["blog_url"] = ""
+		resp["scope"] = "global"
+	} else {
+		resp["blog_id"] = siteID
+		resp["blog_url"] = s.sites[siteID].url
+		resp["scope"] = ""
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleTokenInfo(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.mu.Lock()
+	siteID, ok := s.tokens[q.Get("token")]
+	s.mu.Unlock()
+	if !ok || q.Get("client_id") != ClientID {
+		writeError(w, http.StatusBadRequest, "invalid_token", "The token provided is invalid.")
+		return
+	}
+	info := map[string]string{"client_id": ClientID, "user_id": strconv.Itoa(UserID), "blog_id": siteID, "scope": ""}
+	if siteID == "" {
+		info["blog_id"] = "0"
+		info["scope"] = "global"
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// authorize checks the request's bearer token against siteID ("" for account-level
+// endpoints), writing an error and returning false if it is not accepted.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, siteID string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeError(w, http.StatusUnauthorized, "authorization_required", "An active access token must be used.")
+		return false
+	}
+	granted, valid := s.tokens[token]
+	if !valid || (granted != "" && siteID != "" && granted != siteID) {
+		writeError(w, http.StatusForbidden, "unauthorized", "User cannot access this resource.")
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.authorize(w, r, "") {
+		return
+	}
+	writeJSON(w, http.StatusOK, pick(map[string]interface{}{"ID": UserID, "username": "wpcomtest"}, r.URL.Query().Get("fields")))
+}
+
+func (s *Server) handleMySites(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.authorize(w, r, "") {
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	granted := s.tokens[token]
+
+	ids := make([]string, 0, len(s.sites))
+	for id := range s.sites {
+		if granted == "" || granted == id {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	fields := r.URL.Query().Get("fields")
+	list := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		n, _ := strconv.Atoi(id)
+		list = append(list, pick(map[string]interface{}{"ID": n, "name": s.sites[id].name, "URL": s.sites[id].url}, fields))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sites": list})
+}
+
+// siteFor looks up the site of the request, writing an error if it is unknown or the
+// token does not grant access to it. The caller must hold s.mu.
+func (s *Server) siteFor(w http.ResponseWriter, r *http.Request) (*site, bool) {
+	siteID := r.PathValue("site")
+	st, ok := s.sites[siteID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown_blog", "Unknown blog")
+		return nil, false
+	}
+	if !s.authorize(w, r, siteID) {
+		return nil, false
+	}
+	return st, true
+}
+
+// page applies number (default 20, at most 100) and offset to n items.
+func page(q url.Values, n int) (lo, hi int) {
+	number, err := strconv.Atoi(q.Get("number"))
+	if err != nil || number <= 0 {
+		number = 20
+	}
+	if number > 100 {
+		number = 100
+	}
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	lo = min(max(offset, 0), n)
+	return lo, min(lo+number, n)
+}
+
+func (s *Server) handlePosts(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.siteFor(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	postType := q.Get("type")
+	if postType == "" {
+		postType = "post"
+	}
+	status := q.Get("status")
+	if status == "" {
+		status = "publish"
+	}
+
+	var posts []wpcom.Post
+	for _, p := range st.posts {
+		if (postType == "any" || p.Type == postType) && (status == "any" || p.Status == status) {
+			posts = append(posts, p)
+		}
+	}
+	byModified := q.Get("order_by") == "modified"
+	asc := strings.EqualFold(q.Get("order"), "ASC")
+	sort.Slice(posts, func(i, j int) bool {
+		a, b := posts[i].Date, posts[j].Date
+		if byModified {
+			a, b = posts[i].Modified, posts[j].Modified
+		}
+		if a == b {
+			return posts[i].ID > posts[j].ID
+		}
+		return (a < b) == asc
+	})
+
+	lo, hi := page(q, len(posts))
+	fields := q.Get("fields")
+	list := make([]interface{}, 0, hi-lo)
+	for _, p := range posts[lo:hi] {
+		list = append(list, pick(p, fields))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"found": len(posts), "posts": list})
+}
+
+func (s *Server) handlePost(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.siteFor(w, r)
+	if !ok {
+		return
+	}
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	p, ok := st.posts[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown_post", "Unknown post")
+		return
+	}
+	writeJSON(w, http.StatusOK, pick(p, r.URL.Query().Get("fields")))
+}
+
+func (s *Server) handleMediaList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.siteFor(w, r)
+	if !ok {
+		return
+	}
+	media := make([]Media, 0, len(st.media))
+	for _, m := range st.media {
+		media = append(media, m)
+	}
+	sort.Slice(media, func(i, j int) bool { return media[i].ID > media[j].ID })
+
+	q := r.URL.Query()
+	lo, hi := page(q, len(media))
+	fields := q.Get("fields")
+	list := make([]interface{}, 0, hi-lo)
+	for _, m := range media[lo:hi] {
+		list = append(list, pick(m, fields))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"found": len(media), "media": list})
+}
+
+func (s *Server) handleMedia(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.siteFor(w, r)
+	if !ok {
+		return
+	}
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	m, ok := st.media[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown_media", "Unknown media")
+		return
+	}
+	writeJSON(w, http.StatusOK, pick(m, r.URL.Query().Get("fields")))
+}
+
+// pick returns v with only the comma-separated fields, like the API's fields parameter,
+// or v itself if fields is empty.
+func pick(v interface{}, fields string) interface{} {
+	if fields == "" {
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return v
+	}
+	picked := make(map[string]json.RawMessage)
+	for _, f := range strings.Split(fields, ",") {
+		if raw, ok := all[f]; ok {
+			picked[f] = raw
+		}
+	}
+	return picked
+}